@@ -0,0 +1,131 @@
+package zabbix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActiveCheck is an item the server expects the host to send, as returned
+// by GetActiveChecks.
+type ActiveCheck struct {
+	// Key with the user macros resolved, KeyOrig as configured.
+	Key     string `json:"key"`
+	KeyOrig string `json:"key_orig,omitempty"`
+
+	Delay       Interval `json:"delay"`
+	LastLogSize int64    `json:"lastlogsize"`
+	MTime       int64    `json:"mtime"`
+	ItemID      uint64   `json:"itemid,omitempty"`
+	Timeout     Interval `json:"timeout,omitempty"`
+}
+
+// Regexp is a global regular expression used by the log and file items of
+// the active checks.
+type Regexp struct {
+	Name           string `json:"name"`
+	Expression     string `json:"expression"`
+	ExpressionType int    `json:"expression_type"`
+	ExpDelimiter   string `json:"exp_delimiter"`
+	CaseSensitive  int    `json:"case_sensitive"`
+}
+
+// Interval is an item update interval or timeout. The server sends a number
+// of seconds or, since Zabbix 5.4, a string with a time suffix such as
+// "1m", followed for the update intervals by custom intervals.
+type Interval string
+
+// UnmarshalJSON accept a number or a string.
+func (i *Interval) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*i = Interval(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("interval is neither a number nor a string: %s", data)
+	}
+	*i = Interval(n)
+	return nil
+}
+
+// Duration return the interval as a duration, ignoring the custom intervals.
+func (i Interval) Duration() (time.Duration, error) {
+	s := string(i)
+	if n := strings.IndexByte(s, ';'); n >= 0 {
+		s = s[:n]
+	}
+	s = strings.TrimSpace(s)
+
+	unit := time.Second
+	if s != "" {
+		switch s[len(s)-1] {
+		case 's':
+			s = s[:len(s)-1]
+		case 'm':
+			unit, s = time.Minute, s[:len(s)-1]
+		case 'h':
+			unit, s = time.Hour, s[:len(s)-1]
+		case 'd':
+			unit, s = 24*time.Hour, s[:len(s)-1]
+		case 'w':
+			unit, s = 7*24*time.Hour, s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid interval %q", string(i))
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// GetActiveChecks return the items the server expects from host and the
+// global regular expressions they use. The host is registered on the way
+// if autoregistration is configured for its metadata.
+func (s *Sender) GetActiveChecks(host, hostmetadata string) ([]ActiveCheck, []Regexp, error) {
+	return s.GetActiveChecksContext(context.Background(), host, hostmetadata)
+}
+
+// GetActiveChecksContext is like GetActiveChecks but ctx can cancel the
+// request.
+func (s *Sender) GetActiveChecksContext(ctx context.Context, host, hostmetadata string) ([]ActiveCheck, []Regexp, error) {
+	p := &Packet{Request: "active checks", Host: host, HostMetadata: hostmetadata}
+
+	var res activeChecksResponse
+	_, err := s.retry(ctx, p, func(ctx context.Context, p *Packet) (Response, error) {
+		data, err := s.sendJSON(ctx, p)
+		if err != nil {
+			return Response{}, err
+		}
+		res = activeChecksResponse{}
+		if err := json.Unmarshal(data, &res); err != nil {
+			return Response{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+		}
+		return res.Response, nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("sending packet: %w", err)
+	}
+	if res.Response.Response != "success" {
+		return nil, nil, &ResponseError{Response: res.Response}
+	}
+
+	return res.Data, res.Regexp, nil
+}
+
+// activeChecksResponse is the response to "active checks".
+type activeChecksResponse struct {
+	Response
+	Data   []ActiveCheck `json:"data"`
+	Regexp []Regexp      `json:"regexp"`
+}
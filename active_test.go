@@ -0,0 +1,105 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		json     string
+		interval Interval
+		duration time.Duration
+	}{
+		{`60`, "60", time.Minute},
+		{`"30"`, "30", 30 * time.Second},
+		{`"30s"`, "30s", 30 * time.Second},
+		{`"5m"`, "5m", 5 * time.Minute},
+		{`"1h"`, "1h", time.Hour},
+		{`"1d"`, "1d", 24 * time.Hour},
+		{`"1w"`, "1w", 7 * 24 * time.Hour},
+		{`"10m;wd1-5h9-18"`, "10m;wd1-5h9-18", 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		var i Interval
+		if err := json.Unmarshal([]byte(test.json), &i); err != nil {
+			t.Errorf("unmarshaling %s: %v", test.json, err)
+			continue
+		}
+		if i != test.interval {
+			t.Errorf("unmarshaling %s: got %q, expected %q", test.json, i, test.interval)
+		}
+		if d, err := i.Duration(); err != nil || d != test.duration {
+			t.Errorf("duration of %q: got %v, %v, expected %v", i, d, err, test.duration)
+		}
+	}
+
+	for _, i := range []Interval{"", "1y", "-5", "{$DELAY}"} {
+		if _, err := i.Duration(); err == nil {
+			t.Errorf("expected an error for the duration of %q", i)
+		}
+	}
+}
+
+func TestGetActiveChecks(t *testing.T) {
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		var request ZabbixRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		if request.Request != "active checks" || request.Host != "host" || request.HostMetadata != "linux" {
+			t.Errorf("unexpected request %+v", request)
+		}
+		return zabbixResponse(`{"response":"success","data":[` +
+			`{"key":"net.if.in[eth0]","delay":60,"lastlogsize":0,"mtime":0},` +
+			`{"key":"log[/var/log/app.log,@errors]","key_orig":"log[{$LOG},@errors]","itemid":1234,"delay":"1m","lastlogsize":100,"mtime":5,"timeout":"3s"}],` +
+			`"regexp":[{"name":"errors","expression":"error","expression_type":3,"exp_delimiter":",","case_sensitive":1}]}`)
+	})
+
+	s := NewSender(addr)
+	checks, regexps, err := s.GetActiveChecks("host", "linux")
+	if err != nil {
+		t.Fatalf("error getting active checks: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	expected := []ActiveCheck{
+		{Key: "net.if.in[eth0]", Delay: "60"},
+		{Key: "log[/var/log/app.log,@errors]", KeyOrig: "log[{$LOG},@errors]", ItemID: 1234, Delay: "1m", LastLogSize: 100, MTime: 5, Timeout: "3s"},
+	}
+	if len(checks) != len(expected) {
+		t.Fatalf("expected %d checks, got %+v", len(expected), checks)
+	}
+	for i := range expected {
+		if checks[i] != expected[i] {
+			t.Errorf("check %d: got %+v, expected %+v", i, checks[i], expected[i])
+		}
+	}
+
+	expectedRegexp := Regexp{Name: "errors", Expression: "error", ExpressionType: 3, ExpDelimiter: ",", CaseSensitive: 1}
+	if len(regexps) != 1 || regexps[0] != expectedRegexp {
+		t.Errorf("unexpected regexps %+v", regexps)
+	}
+}
+
+func TestGetActiveChecksError(t *testing.T) {
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		return zabbixResponse(`{"response":"failed","info":"host [host] not found"}`)
+	})
+
+	s := NewSender(addr)
+	_, _, err := s.GetActiveChecks("host", "")
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	var resErr *ResponseError
+	if !errors.As(err, &resErr) || resErr.Response.Info != "host [host] not found" {
+		t.Errorf("expected a response error, got %v", err)
+	}
+}
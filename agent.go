@@ -0,0 +1,206 @@
+package zabbix
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultRefreshInterval = 2 * time.Minute
+	defaultBufferSend      = 5 * time.Second
+	defaultBufferSize      = 100
+	defaultItemTimeout     = 3 * time.Second
+)
+
+// ActiveAgent acts as a Zabbix agent in active mode: it gets from the server
+// the active checks of Host, collects the items at their update interval
+// with the ItemFuncs of Items and sends the values as "agent data" packets
+// in the Sender session.
+type ActiveAgent struct {
+	Sender       *Sender
+	Host         string
+	HostMetadata string
+	Items        *ItemMux
+
+	// RefreshInterval is the interval between two requests of the active
+	// checks, the items are rescheduled when they change. Zero means 2
+	// minutes.
+	RefreshInterval time.Duration
+
+	// BufferSend is the longest time the values are kept before being sent,
+	// and BufferSize the number of values sent without waiting. Zero means
+	// 5 seconds and 100 values. Values which could not be sent are sent
+	// again with the next ones, up to 10 times BufferSize, the oldest being
+	// dropped.
+	BufferSend time.Duration
+	BufferSize int
+
+	// OnError, if set, is called with the errors getting the active checks
+	// and sending the values.
+	OnError func(error)
+}
+
+// NewActiveAgent return an ActiveAgent sending the values of the items of
+// host with s.
+func NewActiveAgent(s *Sender, host string, items *ItemMux) *ActiveAgent {
+	return &ActiveAgent{Sender: s, Host: host, Items: items}
+}
+
+// activeItem is an active check scheduled by ActiveAgent.
+type activeItem struct {
+	check ActiveCheck
+	delay time.Duration
+	next  time.Time
+}
+
+// Run the agent until ctx is done and return its error. The values not sent
+// yet are lost.
+func (a *ActiveAgent) Run(ctx context.Context) error {
+	refreshInterval := a.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+	bufferSend := a.BufferSend
+	if bufferSend <= 0 {
+		bufferSend = defaultBufferSend
+	}
+	bufferSize := a.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	var items []*activeItem
+	var pending []*Metric
+	now := time.Now()
+	refresh, flush := now, now.Add(bufferSend)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		now = time.Now()
+		if !now.Before(refresh) {
+			items = a.sync(ctx, items, now)
+			refresh = now.Add(refreshInterval)
+		}
+
+		for _, item := range items {
+			if now.Before(item.next) {
+				continue
+			}
+			pending = append(pending, a.collect(ctx, item.check))
+			if item.next = item.next.Add(item.delay); !item.next.After(now) {
+				item.next = now.Add(item.delay)
+			}
+		}
+
+		if len(pending) >= bufferSize || !now.Before(flush) {
+			if len(pending) > 0 {
+				pending = a.send(ctx, pending, 10*bufferSize)
+			}
+			flush = time.Now().Add(bufferSend)
+		}
+
+		next := refresh
+		if flush.Before(next) {
+			next = flush
+		}
+		for _, item := range items {
+			if item.next.Before(next) {
+				next = item.next
+			}
+		}
+		timer.Reset(time.Until(next))
+	}
+}
+
+// sync get the active checks and return them scheduled. Unchanged items keep
+// their schedule, the new ones are collected at once. items are returned
+// when the checks can not be got.
+func (a *ActiveAgent) sync(ctx context.Context, items []*activeItem, now time.Time) []*activeItem {
+	checks, _, err := a.Sender.GetActiveChecksContext(ctx, a.Host, a.HostMetadata)
+	if err != nil {
+		if ctx.Err() == nil {
+			a.error(fmt.Errorf("getting active checks: %w", err))
+		}
+		return items
+	}
+
+	scheduled := make(map[string]*activeItem, len(items))
+	for _, item := range items {
+		scheduled[item.check.Key] = item
+	}
+
+	synced := make([]*activeItem, 0, len(checks))
+	for _, check := range checks {
+		delay, err := check.Delay.Duration()
+		if err == nil && delay <= 0 {
+			err = fmt.Errorf("invalid interval %q", string(check.Delay))
+		}
+		if err != nil {
+			a.error(fmt.Errorf("active check %s: %w", check.Key, err))
+			continue
+		}
+
+		item := &activeItem{check: check, delay: delay, next: now}
+		if prev, ok := scheduled[check.Key]; ok && prev.delay == delay {
+			item.next = prev.next
+		}
+		synced = append(synced, item)
+	}
+	return synced
+}
+
+// collect return the value of the item of check, not supported if its
+// ItemFunc fails.
+func (a *ActiveAgent) collect(ctx context.Context, check ActiveCheck) *Metric {
+	timeout, err := check.Timeout.Duration()
+	if err != nil || timeout <= 0 {
+		timeout = defaultItemTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	value, err := a.Items.Get(ctx, check.Key)
+	m := NewMetricTime(a.Host, check.Key, value, true, time.Now())
+	if err != nil {
+		m.Value = notSupportedReason(err)
+		m.State = StateNotSupported
+	}
+	return m
+}
+
+// send the pending values and return those to send again, at most max.
+func (a *ActiveAgent) send(ctx context.Context, pending []*Metric, max int) []*Metric {
+	packet := NewPacket(pending, true)
+	packet.Session = a.Sender.Session()
+
+	_, err := a.Sender.SendContext(ctx, packet)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == nil {
+		a.error(fmt.Errorf("sending values: %w", err))
+	}
+	if !breaksSession(err) {
+		return nil
+	}
+
+	if len(pending) > max {
+		pending = pending[len(pending)-max:]
+	}
+	return pending
+}
+
+// error report err to OnError.
+func (a *ActiveAgent) error(err error) {
+	if a.OnError != nil {
+		a.OnError(err)
+	}
+}
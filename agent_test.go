@@ -0,0 +1,79 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveAgent(t *testing.T) {
+	checks := []string{
+		`{"response":"success","data":[{"key":"echo[a,\"b,c\"]","delay":"1h","lastlogsize":0,"mtime":0}]}`,
+		`{"response":"success","data":[{"key":"echo[a,\"b,c\"]","delay":"1h","lastlogsize":0,"mtime":0},{"key":"missing","delay":3600,"lastlogsize":0,"mtime":0}]}`,
+	}
+
+	var packets []Packet
+	addr, errs := fakeZabbix(t, 4, func(header, data []byte) []byte {
+		var packet Packet
+		if err := json.Unmarshal(data, &packet); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		packets = append(packets, packet)
+		if packet.Request == "active checks" {
+			res := checks[0]
+			checks = checks[1:]
+			return zabbixResponse(res)
+		}
+		return zabbixResponse(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+	})
+
+	items := NewItemMux()
+	items.Handle("echo", func(ctx context.Context, params []string) (string, error) {
+		return strings.Join(params, "|"), nil
+	})
+
+	agent := NewActiveAgent(NewSender(addr), "host", items)
+	agent.RefreshInterval = 100 * time.Millisecond
+	agent.BufferSend = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- agent.Run(ctx) }()
+
+	err := <-errs
+	cancel()
+	if err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return the context error, got %v", err)
+	}
+
+	// The checks, the value of echo, the changed checks and the value of
+	// the new item only
+	requests := []string{"active checks", "agent data", "active checks", "agent data"}
+	for i, p := range packets {
+		if p.Request != requests[i] || p.Host != "host" && p.Request == "active checks" {
+			t.Errorf("request %d: got %s for %s, expected %s", i, p.Request, p.Host, requests[i])
+		}
+	}
+
+	values := packets[1].Data
+	if len(values) != 1 || values[0].Key != `echo[a,"b,c"]` || values[0].Value != "a|b,c" || values[0].State != StateNormal || values[0].Clock == 0 {
+		t.Errorf("unexpected values %+v", values)
+	}
+	values = packets[3].Data
+	if len(values) != 1 || values[0].Key != "missing" || values[0].Value != "Unsupported item key." || values[0].State != StateNotSupported {
+		t.Errorf("unexpected values %+v", values)
+	}
+
+	if packets[1].Session == "" || packets[3].Session != packets[1].Session {
+		t.Errorf("expected the same session, got %q and %q", packets[1].Session, packets[3].Session)
+	}
+	if packets[1].Data[0].ID != 1 || packets[3].Data[0].ID != 2 {
+		t.Errorf("expected increasing ids, got %d and %d", packets[1].Data[0].ID, packets[3].Data[0].ID)
+	}
+}
@@ -0,0 +1,290 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 1000
+	defaultFlushInterval = 5 * time.Second
+)
+
+// ErrBufferClosed is returned when adding metrics to a closed BufferedSender.
+var ErrBufferClosed = errors.New("zabbix: buffered sender closed")
+
+// OverflowPolicy selects what a BufferedSender does with new metrics when its
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the metrics being added.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest buffered metric to make room.
+	OverflowDropOldest
+	// OverflowDropPriority drops the oldest of the buffered metrics with the
+	// lowest priority, or the metric being added when its priority is not
+	// higher than theirs.
+	OverflowDropPriority
+	// OverflowBlock makes Add wait until a flush makes room.
+	OverflowBlock
+)
+
+// DropStats counts the metrics dropped by a BufferedSender, by policy.
+type DropStats struct {
+	Newest   uint64
+	Oldest   uint64
+	Priority uint64
+}
+
+// BufferedConfig configures a BufferedSender.
+type BufferedConfig struct {
+	// BatchSize is the number of buffered metrics which triggers a flush.
+	// Zero means 1000.
+	BatchSize int
+
+	// FlushInterval is the period of the background flushes. Zero means 5
+	// seconds.
+	FlushInterval time.Duration
+
+	// OnFlush, if set, is called with the result of every background flush.
+	// It is called from the flushing goroutine, so it should not block.
+	OnFlush func(*BatchResult)
+
+	// QueueSize bounds the number of buffered metrics, zero means no bound.
+	// When the queue is full, Overflow selects what Add does.
+	QueueSize int
+	Overflow  OverflowPolicy
+
+	// Priority return the priority of a metric for OverflowDropPriority,
+	// higher values are kept longer. Nil means every metric has the same
+	// priority.
+	Priority func(*Metric) int
+}
+
+// BufferedSender buffers metrics and sends them in batches with a Sender
+// from a background goroutine, so adding metrics never waits for the server.
+type BufferedSender struct {
+	sender *Sender
+	config BufferedConfig
+
+	// mu protects pending, closed and dropped
+	mu      sync.Mutex
+	pending metricQueue
+	closed  bool
+	dropped DropStats
+
+	// space is signaled when metrics leave the queue or on Close
+	space *sync.Cond
+
+	// flushMu serializes the flushes, so batches are sent in order
+	flushMu sync.Mutex
+
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	// ctx is canceled when Close gives up waiting for a background flush
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewBufferedSender return a BufferedSender sending with s and start its
+// background flushing goroutine. Close must be called to stop it.
+func NewBufferedSender(s *Sender, config BufferedConfig) *BufferedSender {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+
+	b := &BufferedSender{
+		sender:  s,
+		config:  config,
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	b.space = sync.NewCond(&b.mu)
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	go b.run()
+	return b
+}
+
+// Add buffer metrics to be sent by the next flush. A flush is started in
+// background when the buffer reaches BatchSize or QueueSize. Add does not
+// block, unless the queue is full with the OverflowBlock policy.
+func (b *BufferedSender) Add(metrics ...*Metric) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, m := range metrics {
+		if err := b.enqueue(m); err != nil {
+			return err
+		}
+	}
+
+	if b.pending.len >= b.config.BatchSize || b.full() {
+		b.startFlush()
+	}
+	return nil
+}
+
+// full report if the queue is full, b.mu must be held.
+func (b *BufferedSender) full() bool {
+	return b.config.QueueSize > 0 && b.pending.len >= b.config.QueueSize
+}
+
+// startFlush wake up the background goroutine to flush.
+func (b *BufferedSender) startFlush() {
+	select {
+	case b.kick <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue add m to the queue applying the overflow policy, b.mu must be
+// held.
+func (b *BufferedSender) enqueue(m *Metric) error {
+	if b.closed {
+		return ErrBufferClosed
+	}
+	prio := 0
+	if b.config.Overflow == OverflowDropPriority && b.config.Priority != nil {
+		prio = b.config.Priority(m)
+	}
+	if !b.full() {
+		b.pending.push(m, prio)
+		return nil
+	}
+
+	switch b.config.Overflow {
+	case OverflowBlock:
+		b.startFlush()
+		for !b.closed && b.full() {
+			b.space.Wait()
+		}
+		if b.closed {
+			return ErrBufferClosed
+		}
+		b.pending.push(m, prio)
+
+	case OverflowDropOldest:
+		// Every metric has the same priority, the lowest are the oldest
+		b.pending.dropLowest()
+		b.pending.push(m, prio)
+		b.dropped.Oldest++
+
+	case OverflowDropPriority:
+		if b.config.Priority == nil {
+			b.dropped.Priority++
+			return nil
+		}
+		if lowest, ok := b.pending.lowest(); ok && lowest < prio {
+			b.pending.dropLowest()
+			b.pending.push(m, prio)
+		}
+		b.dropped.Priority++
+
+	default:
+		b.dropped.Newest++
+	}
+	return nil
+}
+
+// Dropped return the number of metrics dropped because the queue was full.
+func (b *BufferedSender) Dropped() DropStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// Len return the number of metrics waiting in the buffer.
+func (b *BufferedSender) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending.len
+}
+
+// Flush send the buffered metrics now and return the error of the batch.
+func (b *BufferedSender) Flush(ctx context.Context) error {
+	if res := b.flush(ctx); res != nil {
+		return res.Err
+	}
+	return nil
+}
+
+// Close stop the background flushes and send the remaining metrics. If ctx
+// is done before, the flush in progress is canceled and the error of ctx is
+// returned.
+func (b *BufferedSender) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBufferClosed
+	}
+	b.closed = true
+	b.space.Broadcast()
+	b.mu.Unlock()
+
+	defer b.cancel()
+	close(b.done)
+
+	// Wait for the background flush in progress
+	select {
+	case <-b.stopped:
+	case <-ctx.Done():
+		b.cancel()
+		<-b.stopped
+		return ctx.Err()
+	}
+
+	return b.Flush(ctx)
+}
+
+// run flush the buffer every FlushInterval or when it is full, until Close.
+func (b *BufferedSender) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		case <-b.kick:
+		}
+
+		if res := b.flush(b.ctx); res != nil && b.config.OnFlush != nil {
+			b.config.OnFlush(res)
+		}
+	}
+}
+
+// flush send the buffered metrics, it return nil when there are none.
+func (b *BufferedSender) flush(ctx context.Context) *BatchResult {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	metrics := b.take()
+	if len(metrics) == 0 {
+		return nil
+	}
+	return b.sender.SendBatchContext(ctx, metrics)
+}
+
+// take return the buffered metrics and empty the queue.
+func (b *BufferedSender) take() []*Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	metrics := b.pending.take()
+	b.space.Broadcast()
+	return metrics
+}
@@ -0,0 +1,191 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBufferedSender(t *testing.T) {
+	requests := make(chan ZabbixRequest, 2)
+	addr, errs := fakeZabbix(t, 2, func(header, data []byte) []byte {
+		var request ZabbixRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		requests <- request
+		return zabbixResponse(`{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"}`)
+	})
+
+	flushed := make(chan *BatchResult, 1)
+	b := NewBufferedSender(NewSender(addr), BufferedConfig{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		OnFlush:       func(res *BatchResult) { flushed <- res },
+	})
+
+	// Reaching the batch size flushes in background
+	if err := b.Add(NewMetric("zabbixTrapper1", "ping", "1", false), NewMetric("zabbixTrapper1", "ping", "2", false)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case res := <-flushed:
+		if res.Err != nil || res.Processed() != 2 {
+			t.Errorf("unexpected flush result: %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch size did not trigger a flush")
+	}
+	if request := <-requests; len(request.Data) != 2 {
+		t.Errorf("expected 2 metrics in the first batch, got %d", len(request.Data))
+	}
+
+	// Close drains the remaining metrics
+	if err := b.Add(NewMetric("zabbixTrapper1", "ping", "3", false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	if request := <-requests; len(request.Data) != 1 || request.Data[0].Value != "3" {
+		t.Errorf("unexpected drained batch: %+v", request)
+	}
+
+	if err := b.Add(NewMetric("zabbixTrapper1", "ping", "4", false)); !errors.Is(err, ErrBufferClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+}
+
+func TestBufferedSenderInterval(t *testing.T) {
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		return zabbixResponse(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+	})
+
+	flushed := make(chan *BatchResult, 1)
+	b := NewBufferedSender(NewSender(addr), BufferedConfig{
+		FlushInterval: 10 * time.Millisecond,
+		OnFlush:       func(res *BatchResult) { flushed <- res },
+	})
+	defer b.Close(context.Background())
+
+	b.Add(NewMetric("zabbixTrapper1", "ping", "1", false))
+	select {
+	case res := <-flushed:
+		if res.Err != nil {
+			t.Errorf("unexpected flush error: %v", res.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("interval did not trigger a flush")
+	}
+
+	if b.Len() != 0 {
+		t.Errorf("expected an empty buffer, got %d metrics", b.Len())
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+}
+
+// newQueue return a BufferedSender without background goroutine to test its
+// queue.
+func newQueue(config BufferedConfig) *BufferedSender {
+	config.BatchSize = 1000
+	b := &BufferedSender{config: config}
+	b.space = sync.NewCond(&b.mu)
+	return b
+}
+
+func values(metrics []*Metric) string {
+	var v []string
+	for _, m := range metrics {
+		v = append(v, m.Value)
+	}
+	return strings.Join(v, ",")
+}
+
+func TestBufferedSenderOverflow(t *testing.T) {
+	priority := func(m *Metric) int {
+		if strings.HasPrefix(m.Key, "high") {
+			return 1
+		}
+		return 0
+	}
+
+	tests := []struct {
+		policy   OverflowPolicy
+		size     int
+		keys     []string
+		expected string
+		dropped  DropStats
+	}{
+		{OverflowDropNewest, 3, []string{"a", "b", "c", "d", "e"}, "0,1,2", DropStats{Newest: 2}},
+		{OverflowDropOldest, 3, []string{"a", "b", "c", "d", "e"}, "2,3,4", DropStats{Oldest: 2}},
+		{OverflowDropPriority, 4, []string{"high", "low", "low", "high", "low", "high"}, "0,2,3,5", DropStats{Priority: 2}},
+	}
+
+	for _, tt := range tests {
+		b := newQueue(BufferedConfig{QueueSize: tt.size, Overflow: tt.policy, Priority: priority})
+
+		for i, key := range tt.keys {
+			if err := b.Add(NewMetric("zabbixTrapper1", key, strconv.Itoa(i), false)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if v := values(b.take()); v != tt.expected {
+			t.Errorf("policy %d: expected queue %s, got %s", tt.policy, tt.expected, v)
+		}
+		if d := b.Dropped(); d != tt.dropped {
+			t.Errorf("policy %d: expected dropped %+v, got %+v", tt.policy, tt.dropped, d)
+		}
+	}
+}
+
+func TestBufferedSenderBlock(t *testing.T) {
+	b := newQueue(BufferedConfig{QueueSize: 1, Overflow: OverflowBlock})
+	b.Add(NewMetric("zabbixTrapper1", "ping", "0", false))
+
+	added := make(chan error)
+	go func() {
+		added <- b.Add(NewMetric("zabbixTrapper1", "ping", "1", false))
+	}()
+
+	select {
+	case <-added:
+		t.Fatal("Add should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if v := values(b.take()); v != "0" {
+		t.Errorf("expected queue 0, got %s", v)
+	}
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if v := values(b.take()); v != "1" {
+		t.Errorf("expected queue 1, got %s", v)
+	}
+
+	// Closing releases blocked producers
+	b.Add(NewMetric("zabbixTrapper1", "ping", "2", false))
+	go func() {
+		added <- b.Add(NewMetric("zabbixTrapper1", "ping", "3", false))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.mu.Lock()
+	b.closed = true
+	b.space.Broadcast()
+	b.mu.Unlock()
+	if err := <-added; !errors.Is(err, ErrBufferClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// packetOverhead is an upper estimate of the bytes a packet adds to the
+// encoded metrics: request, clock and JSON delimiters.
+const packetOverhead = 128
+
+// chunkMetrics split metrics in groups respecting the MaxMetricsPerPacket
+// and MaxPacketSize limits. A metric bigger than MaxPacketSize on its own is
+// put alone in its group.
+func (s *Sender) chunkMetrics(metrics []*Metric) [][]*Metric {
+	if s.MaxMetricsPerPacket <= 0 && s.MaxPacketSize <= 0 {
+		return [][]*Metric{metrics}
+	}
+
+	var chunks [][]*Metric
+	start, size := 0, packetOverhead
+	for i, m := range metrics {
+		var metricSize int
+		if s.MaxPacketSize > 0 {
+			b, _ := json.Marshal(m)
+			metricSize = len(b) + 1 // comma separator
+		}
+
+		n := i - start
+		full := s.MaxMetricsPerPacket > 0 && n >= s.MaxMetricsPerPacket
+		tooBig := s.MaxPacketSize > 0 && size+metricSize > s.MaxPacketSize
+		if n > 0 && (full || tooBig) {
+			chunks = append(chunks, metrics[start:i])
+			start, size = i, packetOverhead
+		}
+		size += metricSize
+	}
+	return append(chunks, metrics[start:])
+}
+
+// sendChunks send the metrics of packet in as many packets as the Sender
+// limits require, up to MaxConcurrency at a time. The results, with their
+// Response and Err set, keep the order of the metrics.
+//
+// The packets of a session are sent one at a time instead, and the ones
+// following a failed packet are not sent: the server would ignore the
+// failed values when they are sent again, their IDs being lower.
+func (s *Sender) sendChunks(ctx context.Context, packet *Packet) []*PacketResult {
+	packet, release, err := s.identify(ctx, packet)
+	if err != nil {
+		return []*PacketResult{{Request: packet.Request, Metrics: packet.Data, Err: err}}
+	}
+
+	// Stamped metrics are bigger, stamp them before measuring them
+	packet = s.stampMetrics(packet)
+	chunks := s.chunkMetrics(packet.Data)
+	results := make([]*PacketResult, len(chunks))
+
+	concurrency := s.MaxConcurrency
+	if concurrency < 1 || packet.Session != "" {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i := range chunks {
+		chunk := *packet
+		chunk.Data = chunks[i]
+		results[i] = &PacketResult{Request: packet.Request, Metrics: chunk.Data}
+
+		sem <- struct{}{}
+		if packet.Session != "" && i > 0 && breaksSession(results[i-1].Err) {
+			results[i].Err = ErrNotSent
+			<-sem
+			continue
+		}
+		wg.Add(1)
+		go func(pr *PacketResult) {
+			defer wg.Done()
+			pr.Response, pr.Err = s.sendPacket(ctx, &chunk)
+			<-sem
+		}(results[i])
+	}
+	wg.Wait()
+	release()
+
+	for _, pr := range results {
+		if pr.Err == nil && len(pr.Metrics) > 0 {
+			s.replaySpool(ctx)
+			break
+		}
+	}
+	return results
+}
+
+// mergeResponses return a single response and error for the results of the
+// chunks of a packet. The info of the responses is added up when every
+// chunk got one. A zero Response is returned when a chunk got no response,
+// and the first response without info when one can not be parsed.
+func mergeResponses(results []*PacketResult) (Response, error) {
+	if len(results) == 1 {
+		return results[0].Response, results[0].Err
+	}
+
+	var errs []error
+	for _, pr := range results {
+		if pr.Err != nil {
+			errs = append(errs, pr.Err)
+		}
+	}
+
+	total := new(ResponseInfo)
+	for _, pr := range results {
+		if pr.Response.Response == "" {
+			return Response{}, errors.Join(errs...)
+		}
+		info, err := pr.Response.GetInfo()
+		if err != nil {
+			return pr.Response, errors.Join(errs...)
+		}
+		total.add(info)
+	}
+
+	return Response{Response: "success", Info: total.String()}, errors.Join(errs...)
+}
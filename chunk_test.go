@@ -0,0 +1,131 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkMetrics(t *testing.T) {
+	var metrics []*Metric
+	for i := 0; i < 10; i++ {
+		metrics = append(metrics, NewMetric("zabbixTrapper1", fmt.Sprintf("key%d", i), "13", false))
+	}
+
+	s := NewSender("127.0.0.1:10051")
+	if chunks := s.chunkMetrics(metrics); len(chunks) != 1 || len(chunks[0]) != 10 {
+		t.Errorf("expected a single chunk without limits, got %d", len(chunks))
+	}
+
+	s.MaxMetricsPerPacket = 4
+	chunks := s.chunkMetrics(metrics)
+	if len(chunks) != 3 || len(chunks[0]) != 4 || len(chunks[1]) != 4 || len(chunks[2]) != 2 {
+		t.Errorf("unexpected chunks by metric count: %v", chunks)
+	}
+
+	b, _ := json.Marshal(metrics[0])
+	s.MaxMetricsPerPacket = 0
+	s.MaxPacketSize = packetOverhead + 3*(len(b)+1)
+	chunks = s.chunkMetrics(metrics)
+	if len(chunks) != 4 || len(chunks[0]) != 3 || len(chunks[3]) != 1 {
+		t.Errorf("unexpected chunks by size: %v", chunks)
+	}
+	for _, chunk := range chunks {
+		data, _ := json.Marshal(NewPacket(chunk, false))
+		if len(data) > s.MaxPacketSize {
+			t.Errorf("packet of %d bytes exceeds the limit of %d", len(data), s.MaxPacketSize)
+		}
+	}
+
+	// A metric bigger than the limit is sent alone
+	s.MaxPacketSize = 10
+	if chunks = s.chunkMetrics(metrics[:2]); len(chunks) != 2 {
+		t.Errorf("expected one chunk per metric, got %v", chunks)
+	}
+}
+
+func TestSendMetricsChunked(t *testing.T) {
+	var metrics []*Metric
+	for i := 0; i < 10; i++ {
+		metrics = append(metrics, NewMetric("zabbixTrapper1", fmt.Sprintf("key%d", i), "13", false))
+	}
+
+	addr, errs := fakeZabbix(t, 8, func(header, data []byte) []byte {
+		var request ZabbixRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		if len(request.Data) > 3 {
+			t.Errorf("packet with %d metrics exceeds the limit", len(request.Data))
+		}
+
+		var failed int
+		for _, m := range request.Data {
+			if strings.HasSuffix(m.Key, "9") {
+				failed++
+			}
+		}
+		return zabbixResponse(fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: %d; total: %d; seconds spent: 0.000010"}`,
+			len(request.Data)-failed, failed, len(request.Data)))
+	})
+
+	s := NewSender(addr)
+	s.MaxMetricsPerPacket = 3
+	s.MaxConcurrency = 2
+
+	res := s.SendBatch(metrics)
+	if res.Err != nil {
+		t.Fatalf("error sending chunked batch: %v", res.Err)
+	}
+	if len(res.Packets) != 4 {
+		t.Fatalf("expected 4 packets, got %d", len(res.Packets))
+	}
+	if res.Packets[3].Metrics[0] != metrics[9] {
+		t.Errorf("packet results are not in the metrics order")
+	}
+	if info := res.Info(); info.Processed != 9 || info.Failed != 1 || info.Total != 10 {
+		t.Errorf("unexpected merged info %+v", info)
+	}
+
+	_, _, resTrapper, errTrapper := s.SendMetrics(metrics)
+	if errTrapper != nil {
+		t.Fatalf("error sending chunked trapper metrics: %v", errTrapper)
+	}
+	info, err := resTrapper.GetInfo()
+	if err != nil {
+		t.Fatalf("merged response is not valid: %v", err)
+	}
+	if info.Processed != 9 || info.Failed != 1 || info.Total != 10 || info.Spent != 40*time.Microsecond {
+		t.Errorf("unexpected merged info %+v", info)
+	}
+
+	if err = <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+}
+
+func TestSendMetricsChunkedUnreachable(t *testing.T) {
+	// Get a free port with nothing listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	s := NewSender(addr)
+	s.MaxMetricsPerPacket = 1
+	_, _, res, err := s.SendMetrics([]*Metric{
+		NewMetric("zabbixTrapper1", "key1", "1", false),
+		NewMetric("zabbixTrapper1", "key2", "2", false),
+	})
+	if err == nil {
+		t.Fatal("expected an error sending to a closed port")
+	}
+	if res != (Response{}) {
+		t.Errorf("expected no response, got %+v", res)
+	}
+}
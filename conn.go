@@ -0,0 +1,64 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// dial connects to addr within timeout, or until ctx is done. A zero
+// timeout means no timeout.
+func dial(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// deadline return the earliest of now plus timeout and the ctx deadline. A
+// zero time means no deadline.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var t time.Time
+	if timeout > 0 {
+		t = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		t = d
+	}
+	return t
+}
+
+// watchContext interrupts the I/O in progress on conn when ctx is done. The
+// returned function must be called once conn is no longer used.
+//
+// A deadline set on conn after ctx is done overrides the interruption, so
+// callers must check ctx.Err() after setting a new deadline.
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// A deadline in the past aborts any blocked read or write
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// contextError return the ctx error when err was caused by ctx being done,
+// err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// The connection deadline may expire just before the context one
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package zabbix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Sentinel errors to test with errors.Is the errors returned by Sender.
+var (
+	// ErrConnectTimeout, ErrWriteTimeout and ErrReadTimeout match an
+	// OpError caused by a timeout, either the Sender's or the deadline of
+	// the context.
+	ErrConnectTimeout = errors.New("zabbix: connect timeout")
+	ErrWriteTimeout   = errors.New("zabbix: write timeout")
+	ErrReadTimeout    = errors.New("zabbix: read timeout")
+
+	// ErrProtocol matches every error caused by an invalid frame.
+	ErrProtocol = errors.New("zabbix: protocol error")
+
+	// ErrInvalidResponse matches a response whose JSON or info can not be
+	// decoded.
+	ErrInvalidResponse = errors.New("zabbix response is not valid")
+
+	// ErrServerRejected matches a ResponseError.
+	ErrServerRejected = errors.New("zabbix: request rejected by the server")
+
+	// ErrPartialFailure matches a PartialFailureError.
+	ErrPartialFailure = errors.New("zabbix: server failed to process some values")
+
+	// ErrNotSent is the error of the packets of a session which were not
+	// sent because a previous packet failed.
+	ErrNotSent = errors.New("zabbix: packet not sent after a previous failure")
+
+	// ErrNotSupported matches a NotSupportedError.
+	ErrNotSupported = errors.New("zabbix: item not supported")
+)
+
+// Operations of an OpError.
+const (
+	OpConnect = "connect"
+	OpWrite   = "write"
+	OpRead    = "read"
+)
+
+// OpError is the error of a network operation with the server. Err is the
+// underlying error, usually a *net.OpError, a frame error or a context error.
+type OpError struct {
+	// Op is OpConnect, OpWrite or OpRead.
+	Op   string
+	Addr string
+	// Timeout configured for the operation.
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *OpError) Error() string {
+	var action string
+	switch e.Op {
+	case OpConnect:
+		action = "connecting to server"
+	case OpWrite:
+		action = "sending the data"
+	case OpRead:
+		action = "reading the response"
+	default:
+		action = e.Op
+	}
+	return fmt.Sprintf("%s (timeout=%v): %v", action, e.Timeout, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is match the timeout sentinel error of the operation.
+func (e *OpError) Is(target error) bool {
+	switch target {
+	case ErrConnectTimeout:
+		return e.Op == OpConnect && e.timeout()
+	case ErrWriteTimeout:
+		return e.Op == OpWrite && e.timeout()
+	case ErrReadTimeout:
+		return e.Op == OpRead && e.timeout()
+	}
+	return false
+}
+
+// timeout report if the operation failed because of a timeout.
+func (e *OpError) timeout() bool {
+	if errors.Is(e.Err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
+// ResponseError is returned when the server does not reply "success".
+type ResponseError struct {
+	Response Response
+}
+
+func (e *ResponseError) Error() string {
+	if e.Response.Info == "" {
+		return fmt.Sprintf("zabbix: server replied %q", e.Response.Response)
+	}
+	return fmt.Sprintf("zabbix: server replied %q: %s", e.Response.Response, e.Response.Info)
+}
+
+// Is makes errors.Is match ErrServerRejected.
+func (e *ResponseError) Is(target error) bool {
+	return target == ErrServerRejected
+}
+
+// NotSupportedError is returned by Getter when the agent replies that the
+// item is not supported, with its reason.
+type NotSupportedError struct {
+	Key    string
+	Reason string
+}
+
+func (e *NotSupportedError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("zabbix: item %s not supported", e.Key)
+	}
+	return fmt.Sprintf("zabbix: item %s not supported: %s", e.Key, e.Reason)
+}
+
+// Is makes errors.Is match ErrNotSupported.
+func (e *NotSupportedError) Is(target error) bool {
+	return target == ErrNotSupported
+}
+
+// PartialFailureError is returned when the server processed the packet but
+// failed some of its values, for example values of items which do not exist.
+type PartialFailureError struct {
+	Info *ResponseInfo
+	// Metrics sent in the packet.
+	Metrics []*Metric
+	// Rejected metrics found in Sender.Bisect mode, nil otherwise.
+	Rejected []*Metric
+}
+
+func (e *PartialFailureError) Error() string {
+	return fmt.Sprintf("zabbix: server failed %d of %d values", e.Info.Failed, e.Info.Total)
+}
+
+// Is makes errors.Is match ErrPartialFailure.
+func (e *PartialFailureError) Is(target error) bool {
+	return target == ErrPartialFailure
+}
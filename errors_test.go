@@ -0,0 +1,123 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConnectError(t *testing.T) {
+	// Get a free port with nothing listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = NewSender(addr).Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != OpConnect || opErr.Addr != addr {
+		t.Fatalf("expected connect OpError, got %v", err)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected the connection refused error to be wrapped, got %v", err)
+	}
+	if errors.Is(err, ErrConnectTimeout) {
+		t.Errorf("connection refused should not match ErrConnectTimeout")
+	}
+}
+
+func TestReadTimeoutError(t *testing.T) {
+	// Zabbix server that accepts the connection but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(time.Second)
+	}()
+
+	s := NewSender(listener.Addr().String())
+	s.ReadTimeout = 50 * time.Millisecond
+	_, err = s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("expected read timeout error, got %v", err)
+	}
+	if errors.Is(err, ErrWriteTimeout) || errors.Is(err, ErrConnectTimeout) {
+		t.Errorf("read timeout should not match other timeouts: %v", err)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected the net error to be wrapped, got %v", err)
+	}
+}
+
+func TestResponseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response []byte
+		expected error
+	}{
+		{"bad magic", []byte("BXD\x01\x00\x00\x00\x00\x00\x00\x00\x00"), ErrProtocol},
+		{"truncated", []byte("ZBXD\x01\x10\x00\x00\x00\x00\x00\x00\x00{}"), ErrProtocol},
+		{"invalid JSON", zabbixResponse(`{"response":`), ErrInvalidResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+				return tt.response
+			})
+
+			_, err := NewSender(addr).Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+
+			if err = <-errs; err != nil {
+				t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+			}
+		})
+	}
+
+	var syntaxErr *json.SyntaxError
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		return zabbixResponse(`{"response"}`)
+	})
+	_, err := NewSender(addr).Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("expected the JSON error to be wrapped, got %v", err)
+	}
+	<-errs
+}
+
+func TestServerRejectedError(t *testing.T) {
+	res := Response{Response: "failed", Info: "host [prueba] not found"}
+	_, err := res.GetInfo()
+
+	var resErr *ResponseError
+	if !errors.Is(err, ErrServerRejected) || !errors.As(err, &resErr) {
+		t.Fatalf("expected ResponseError, got %v", err)
+	}
+	if resErr.Response != res {
+		t.Errorf("expected response %+v, got %+v", res, resErr.Response)
+	}
+
+	res = Response{Response: "success", Info: "processed: a; failed: 0; total: 1; seconds spent: 0.000030"}
+	if _, err = res.GetInfo(); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("expected invalid response error, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package zabbix
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Getter gets item values from a Zabbix agent in passive mode, as
+// zabbix_get does.
+type Getter struct {
+	// Host is the address of the agent, "host:10050".
+	Host string
+
+	// Timeouts of each step of a get, zero means no timeout. The deadline
+	// of the context given to GetContext also applies.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	// MaxResponseSize limits the size of the value accepted from the agent,
+	// uncompressed. Zero means 16 MiB.
+	MaxResponseSize uint64
+}
+
+// NewGetter return a getter querying the agent at host using default values
+// for timeouts.
+func NewGetter(host string) *Getter {
+	return &Getter{
+		Host:           host,
+		ConnectTimeout: defaultConnectTimeout,
+		ReadTimeout:    defaultReadTimeout,
+		WriteTimeout:   defaultWriteTimeout,
+	}
+}
+
+// Get return the value of the item key. A NotSupportedError is returned
+// when the agent does not support the item.
+func (g *Getter) Get(key string) (string, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get but ctx can cancel the connection, the sending of
+// the key and the reading of the value. Its deadline applies in addition to
+// the Getter timeouts.
+func (g *Getter) GetContext(ctx context.Context, key string) (string, error) {
+	s := &Sender{
+		Host:            g.Host,
+		ConnectTimeout:  g.ConnectTimeout,
+		ReadTimeout:     g.ReadTimeout,
+		WriteTimeout:    g.WriteTimeout,
+		MaxResponseSize: g.MaxResponseSize,
+	}
+	data, err := s.roundTrip(ctx, func(enc *Encoder) error { return enc.Encode([]byte(key)) })
+	if err != nil {
+		return "", err
+	}
+
+	value := string(data)
+	if reason, ok := strings.CutPrefix(value, "ZBX_NOTSUPPORTED"); ok {
+		return "", &NotSupportedError{Key: key, Reason: strings.TrimPrefix(reason, "\x00")}
+	}
+	return value, nil
+}
@@ -0,0 +1,76 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGetter(t *testing.T) {
+	items := NewItemMux()
+	items.Handle("agent.ping", func(ctx context.Context, params []string) (string, error) {
+		return "1", nil
+	})
+	items.Handle("disk", func(ctx context.Context, params []string) (string, error) {
+		return "", &NotSupportedError{Reason: "Cannot obtain filesystem information."}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := NewPassiveAgent(items)
+	go agent.Serve(listener)
+	defer agent.Close()
+
+	g := NewGetter(listener.Addr().String())
+	if value, err := g.Get("agent.ping"); err != nil || value != "1" {
+		t.Errorf("unexpected value %q, error %v", value, err)
+	}
+
+	_, err = g.Get("disk[/]")
+	var notSupported *NotSupportedError
+	if !errors.Is(err, ErrNotSupported) || !errors.As(err, &notSupported) {
+		t.Fatalf("expected not supported error, got %v", err)
+	}
+	if notSupported.Key != "disk[/]" || notSupported.Reason != "Cannot obtain filesystem information." {
+		t.Errorf("unexpected error %+v", notSupported)
+	}
+
+	if _, err = g.Get("missing"); !errors.As(err, &notSupported) || notSupported.Reason != "Unsupported item key." {
+		t.Errorf("expected unsupported item key error, got %v", err)
+	}
+}
+
+func TestGetterTimeout(t *testing.T) {
+	// An agent which never replies
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	g := NewGetter(listener.Addr().String())
+	g.ReadTimeout = 50 * time.Millisecond
+	if _, err := g.Get("agent.ping"); !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("expected read timeout error, got %v", err)
+	}
+
+	g.ReadTimeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetContext(ctx, "agent.ping"); !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("expected context deadline error, got %v", err)
+	}
+}
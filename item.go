@@ -0,0 +1,160 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedItem is returned by ItemMux for the keys without ItemFunc.
+var ErrUnsupportedItem = errors.New("zabbix: unsupported item key")
+
+// ItemFunc return the value of an item, params being the parameters of its
+// key. An error makes the item not supported, its message being the reason.
+// ctx is done when the item timeout expires.
+type ItemFunc func(ctx context.Context, params []string) (string, error)
+
+// ItemMux dispatches item keys to the ItemFunc registered for their name.
+type ItemMux struct {
+	mu    sync.RWMutex
+	items map[string]ItemFunc
+}
+
+// NewItemMux return an empty ItemMux.
+func NewItemMux() *ItemMux {
+	return &ItemMux{items: make(map[string]ItemFunc)}
+}
+
+// Handle register f for the keys named name, "system.cpu.load" for
+// "system.cpu.load[all,avg1]".
+func (m *ItemMux) Handle(name string, f ItemFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[name] = f
+}
+
+// Get return the value of the item key.
+func (m *ItemMux) Get(ctx context.Context, key string) (string, error) {
+	name, params, err := ParseKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	f, ok := m.items[name]
+	m.mu.RUnlock()
+	if !ok {
+		return "", ErrUnsupportedItem
+	}
+	return f(ctx, params)
+}
+
+// notSupportedReason return the reason sent to the server for an item which
+// failed with err.
+func notSupportedReason(err error) string {
+	var notSupported *NotSupportedError
+	switch {
+	case errors.Is(err, ErrUnsupportedItem):
+		return "Unsupported item key."
+	case errors.As(err, &notSupported):
+		return notSupported.Reason
+	}
+	return err.Error()
+}
+
+// ParseKey split an item key in its name and parameters, unquoted. Array
+// parameters are returned as a single parameter without brackets.
+// https://www.zabbix.com/documentation/current/en/manual/config/items/item/key
+func ParseKey(key string) (name string, params []string, err error) {
+	i := strings.IndexByte(key, '[')
+	if i < 0 {
+		i = len(key)
+	}
+	name = key[:i]
+	if name == "" {
+		return "", nil, fmt.Errorf("invalid item key %q: empty name", key)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return "", nil, fmt.Errorf("invalid item key %q: invalid character %q in name", key, c)
+		}
+	}
+	if i == len(key) {
+		return name, nil, nil
+	}
+
+	rest := key[i+1:]
+	for {
+		var param string
+		if param, rest, err = parseParam(rest); err != nil {
+			return "", nil, fmt.Errorf("invalid item key %q: %w", key, err)
+		}
+		params = append(params, param)
+
+		if rest == "" {
+			return "", nil, fmt.Errorf("invalid item key %q: missing closing bracket", key)
+		}
+		if rest[0] == ']' {
+			if rest[1:] != "" {
+				return "", nil, fmt.Errorf("invalid item key %q: characters after closing bracket", key)
+			}
+			return name, params, nil
+		}
+		rest = rest[1:] // comma
+	}
+}
+
+// parseParam return the parameter at the start of s and what follows it,
+// starting with the comma or the closing bracket.
+func parseParam(s string) (param, rest string, err error) {
+	s = strings.TrimLeft(s, " ")
+	switch {
+	case strings.HasPrefix(s, `"`):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s) && s[i+1] == '"':
+				b.WriteByte('"')
+				i++
+			case s[i] == '"':
+				rest = strings.TrimLeft(s[i+1:], " ")
+				if rest != "" && rest[0] != ',' && rest[0] != ']' {
+					return "", "", errors.New("characters after quoted parameter")
+				}
+				return b.String(), rest, nil
+			default:
+				b.WriteByte(s[i])
+			}
+		}
+		return "", "", errors.New("unterminated quoted parameter")
+
+	case strings.HasPrefix(s, "["):
+		// Skip the nested parameters, quoted ones may contain brackets
+		for i, quoted := 1, false; i < len(s); i++ {
+			switch {
+			case quoted && s[i] == '\\' && i+1 < len(s) && s[i+1] == '"':
+				i++
+			case s[i] == '"':
+				quoted = !quoted
+			case !quoted && s[i] == '[':
+				return "", "", errors.New("nested arrays are not supported")
+			case !quoted && s[i] == ']':
+				rest = strings.TrimLeft(s[i+1:], " ")
+				if rest != "" && rest[0] != ',' && rest[0] != ']' {
+					return "", "", errors.New("characters after array parameter")
+				}
+				return s[1:i], rest, nil
+			}
+		}
+		return "", "", errors.New("unterminated array parameter")
+
+	default:
+		i := strings.IndexAny(s, ",]")
+		if i < 0 {
+			return s, "", nil
+		}
+		return s[:i], s[i:], nil
+	}
+}
@@ -0,0 +1,56 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		name   string
+		params []string
+	}{
+		{"agent.ping", "agent.ping", nil},
+		{"system.cpu.load[all,avg1]", "system.cpu.load", []string{"all", "avg1"}},
+		{"vfs.fs.size[/,]", "vfs.fs.size", []string{"/", ""}},
+		{"key[]", "key", []string{""}},
+		{`key[ a, "b,c" ,"d\"e]"]`, "key", []string{"a", "b,c", `d"e]`}},
+		{`key[a,[b,"c]"],d]`, "key", []string{"a", `b,"c]"`, "d"}},
+		{"web.page.get[localhost,,80]", "web.page.get", []string{"localhost", "", "80"}},
+	}
+
+	for _, test := range tests {
+		name, params, err := ParseKey(test.key)
+		if err != nil {
+			t.Errorf("parsing %s: %v", test.key, err)
+			continue
+		}
+		if name != test.name || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("parsing %s: got %q %q, expected %q %q", test.key, name, params, test.name, test.params)
+		}
+	}
+
+	for _, key := range []string{"", "[a]", "key[a", `key["a]`, `key["a"b]`, "key[a]b", "ke y", "key[[a,[b]]]"} {
+		if _, _, err := ParseKey(key); err == nil {
+			t.Errorf("expected an error parsing %q", key)
+		}
+	}
+}
+
+func TestItemMux(t *testing.T) {
+	mux := NewItemMux()
+	mux.Handle("echo", func(ctx context.Context, params []string) (string, error) {
+		return strings.Join(params, "|"), nil
+	})
+
+	if value, err := mux.Get(context.Background(), `echo[a,"b,c"]`); err != nil || value != "a|b,c" {
+		t.Errorf("unexpected value %q, error %v", value, err)
+	}
+	if _, err := mux.Get(context.Background(), "missing"); !errors.Is(err, ErrUnsupportedItem) {
+		t.Errorf("expected unsupported item error, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package zabbix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+const (
+	defaultPassiveAddr = ":10050"
+
+	// notSupported prefixes the reason of a not supported passive item.
+	notSupported = "ZBX_NOTSUPPORTED\x00"
+
+	// maxKeySize limits the size of the passive requests.
+	maxKeySize = 64 << 10
+)
+
+// PassiveAgent acts as a Zabbix agent in passive mode: the server connects
+// to it to get the value of an item, which it gets with the ItemFunc of
+// Items. Items which fail are reported as not supported.
+type PassiveAgent struct {
+	Items *ItemMux
+
+	// Timeout limits the reading of the request, the getting of the item
+	// value and the writing of the response. Zero means 3 seconds.
+	Timeout time.Duration
+
+	srv server
+}
+
+// NewPassiveAgent return a PassiveAgent serving items.
+func NewPassiveAgent(items *ItemMux) *PassiveAgent {
+	return &PassiveAgent{Items: items}
+}
+
+// ListenAndServe listen on the TCP address addr, ":10050" if empty, and
+// serve the connections. It return ErrServerClosed once the agent is closed.
+func (a *PassiveAgent) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = defaultPassiveAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return a.Serve(l)
+}
+
+// Serve the connections accepted on l. It return ErrServerClosed once the
+// agent is closed.
+func (a *PassiveAgent) Serve(l net.Listener) error {
+	return a.srv.serve(l, a.handle)
+}
+
+// Close the listeners and the connections in progress.
+func (a *PassiveAgent) Close() error {
+	return a.srv.close()
+}
+
+// passiveRequest is the request of the passive checks sent by Zabbix 7.0 and
+// newer, older servers only send the item key.
+type passiveRequest struct {
+	Request string `json:"request"`
+	Data    []struct {
+		Key     string   `json:"key"`
+		Timeout Interval `json:"timeout"`
+	} `json:"data"`
+}
+
+// passiveValue is the value of an item in a passiveResponse.
+type passiveValue struct {
+	Value *string `json:"value,omitempty"`
+	Error *string `json:"error,omitempty"`
+}
+
+// passiveResponse is the response to a passiveRequest.
+type passiveResponse struct {
+	Version string         `json:"version"`
+	Data    []passiveValue `json:"data"`
+}
+
+// handle a connection of the server, one request per connection.
+func (a *PassiveAgent) handle(ctx context.Context, conn net.Conn) {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = defaultItemTimeout
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	data, err := readRequest(bufio.NewReader(conn), maxKeySize)
+	if err != nil {
+		return
+	}
+
+	enc := NewEncoder(conn)
+	var request passiveRequest
+	if bytes.HasPrefix(data, []byte("{")) && json.Unmarshal(data, &request) == nil && request.Request == "passive checks" {
+		res := a.getAll(ctx, request, timeout)
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		enc.EncodeJSON(res)
+		return
+	}
+
+	value, err := a.get(ctx, string(data), timeout)
+	if err != nil {
+		value = notSupported + notSupportedReason(err)
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	enc.Encode([]byte(value))
+}
+
+// getAll return the values of the items of request, each within its timeout
+// or the default one.
+func (a *PassiveAgent) getAll(ctx context.Context, request passiveRequest, timeout time.Duration) *passiveResponse {
+	res := &passiveResponse{Version: "7.0.0", Data: make([]passiveValue, len(request.Data))}
+	for i, item := range request.Data {
+		t, err := item.Timeout.Duration()
+		if err != nil || t <= 0 {
+			t = timeout
+		}
+		value, err := a.get(ctx, item.Key, t)
+		if err != nil {
+			reason := notSupportedReason(err)
+			res.Data[i].Error = &reason
+		} else {
+			res.Data[i].Value = &value
+		}
+	}
+	return res
+}
+
+// get return the value of the item key, within timeout.
+func (a *PassiveAgent) get(ctx context.Context, key string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return a.Items.Get(ctx, key)
+}
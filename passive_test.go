@@ -0,0 +1,85 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// passiveGet send request to the agent at addr and return its response.
+func passiveGet(t *testing.T, addr string, request []byte) string {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := NewDecoder(conn).Decode()
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return string(data)
+}
+
+func TestPassiveAgent(t *testing.T) {
+	items := NewItemMux()
+	items.Handle("echo", func(ctx context.Context, params []string) (string, error) {
+		return strings.Join(params, "|"), nil
+	})
+	items.Handle("fail", func(ctx context.Context, params []string) (string, error) {
+		return "", errors.New("Cannot obtain value.")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := NewPassiveAgent(items)
+	served := make(chan error, 1)
+	go func() { served <- agent.Serve(listener) }()
+	addr := listener.Addr().String()
+
+	tests := []struct {
+		request  []byte
+		response string
+	}{
+		{frame(Header{Flags: FlagZabbixProtocol, DataLength: 9}, []byte("echo[a,b]")), "a|b"},
+		{[]byte("echo[c]\n"), "c"},
+		{[]byte("echo[d]\r\n"), "d"},
+		{zabbixResponse("missing"), "ZBX_NOTSUPPORTED\x00Unsupported item key."},
+		{zabbixResponse("fail"), "ZBX_NOTSUPPORTED\x00Cannot obtain value."},
+		{zabbixResponse("bad[key"), `ZBX_NOTSUPPORTED` + "\x00" + `invalid item key "bad[key": missing closing bracket`},
+		{
+			zabbixResponse(`{"request":"passive checks","data":[{"key":"echo[e]","timeout":"3s"}]}`),
+			`{"version":"7.0.0","data":[{"value":"e"}]}`,
+		},
+		{
+			zabbixResponse(`{"request":"passive checks","data":[{"key":"missing","timeout":"3s"}]}`),
+			`{"version":"7.0.0","data":[{"error":"Unsupported item key."}]}`,
+		},
+	}
+	for _, test := range tests {
+		if res := passiveGet(t, addr, test.request); res != test.response {
+			t.Errorf("request %q: got %q, expected %q", test.request, res, test.response)
+		}
+	}
+
+	if err := agent.Close(); err != nil {
+		t.Errorf("error closing agent: %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("expected Serve to return ErrServerClosed, got %v", err)
+	}
+	if err := agent.Serve(listener); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed serving a closed agent, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package zabbix
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+)
+
+// Protocol header flags.
+// https://www.zabbix.com/documentation/current/en/manual/appendix/protocols/header_datalen
+const (
+	FlagZabbixProtocol byte = 0x01
+	FlagCompressed     byte = 0x02
+	FlagLargePacket    byte = 0x04
+)
+
+const (
+	headerMagic = "ZBXD"
+
+	// maxDataLen is the biggest length that fits in a standard header.
+	maxDataLen = math.MaxUint32
+
+	// defaultMaxFrameSize is the default limit of the Decoder.
+	defaultMaxFrameSize = 16 << 20
+
+	// maxHeaderSize is the size of a large packet header.
+	maxHeaderSize = 21
+
+	// maxPooledBuffer is the biggest buffer kept in bufferPool, so a few
+	// huge packets do not pin their memory.
+	maxPooledBuffer = 4 << 20
+)
+
+// bufferPool holds the buffers used to encode frames. Their first
+// maxHeaderSize bytes are reserved for the header, so it can be written in
+// front of the data without copying it.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// zlibPool holds the zlib writers used to compress frames.
+var zlibPool = sync.Pool{
+	New: func() interface{} { return zlib.NewWriter(nil) },
+}
+
+// getBuffer return an empty buffer from bufferPool with the header space
+// reserved.
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.Write(make([]byte, maxHeaderSize))
+	return buf
+}
+
+// putBuffer return buf to bufferPool.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// Errors returned when a frame is not valid, all of them match ErrProtocol.
+var (
+	ErrTruncatedFrame = fmt.Errorf("%w: truncated frame", ErrProtocol)
+	ErrBadMagic       = fmt.Errorf("%w: bad protocol magic", ErrProtocol)
+	ErrUnknownFlag    = fmt.Errorf("%w: unknown protocol flag", ErrProtocol)
+	ErrLengthMismatch = fmt.Errorf("%w: frame length mismatch", ErrProtocol)
+	ErrFrameTooLarge  = fmt.Errorf("%w: frame exceeds the maximum size", ErrProtocol)
+)
+
+// PacketTooLargeError is returned instead of sending a frame whose length
+// does not fit in the protocol header.
+type PacketTooLargeError struct {
+	Size  uint64
+	Limit uint64
+}
+
+func (e *PacketTooLargeError) Error() string {
+	return fmt.Sprintf("packet of %d bytes exceeds the protocol limit of %d bytes", e.Size, e.Limit)
+}
+
+// Header of a zabbix protocol frame: "ZBXD", flags and the datalen field.
+type Header struct {
+	Flags byte
+	// DataLength is the length of the data following the header.
+	DataLength uint64
+	// Reserved holds the uncompressed data length when the frame is
+	// compressed, it is zero otherwise.
+	Reserved uint64
+}
+
+// Size return the length of the encoded header, 13 bytes or 21 bytes for
+// large packets.
+func (h Header) Size() int {
+	if h.Flags&FlagLargePacket != 0 {
+		return 21
+	}
+	return 13
+}
+
+// MarshalBinary return the header encoded as sent on the wire.
+func (h Header) MarshalBinary() ([]byte, error) {
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, h.Size())
+	h.put(b)
+	return b, nil
+}
+
+// check return an error when the lengths do not fit in the header.
+func (h Header) check() error {
+	if h.Flags&FlagLargePacket == 0 && (h.DataLength > maxDataLen || h.Reserved > maxDataLen) {
+		size := h.DataLength
+		if h.Reserved > size {
+			size = h.Reserved
+		}
+		return &PacketTooLargeError{Size: size, Limit: maxDataLen}
+	}
+	return nil
+}
+
+// put write the header in b, which must be at least h.Size() bytes long.
+func (h Header) put(b []byte) {
+	copy(b, headerMagic)
+	b[4] = h.Flags
+	if h.Flags&FlagLargePacket != 0 {
+		binary.LittleEndian.PutUint64(b[5:13], h.DataLength)
+		binary.LittleEndian.PutUint64(b[13:21], h.Reserved)
+	} else {
+		binary.LittleEndian.PutUint32(b[5:9], uint32(h.DataLength))
+		binary.LittleEndian.PutUint32(b[9:13], uint32(h.Reserved))
+	}
+}
+
+// ReadHeader read and validate a frame header from r.
+func ReadHeader(r io.Reader) (Header, error) {
+	var h Header
+
+	b := make([]byte, 21)
+	if err := readFull(r, b[:5]); err != nil {
+		return h, fmt.Errorf("receiving header: %w", err)
+	}
+
+	if !bytes.Equal(b[:4], []byte(headerMagic)) {
+		return h, fmt.Errorf("%w: got [%+v], expected [%+v]", ErrBadMagic, b[:4], []byte(headerMagic))
+	}
+
+	h.Flags = b[4]
+	if h.Flags&FlagZabbixProtocol == 0 || h.Flags&^(FlagZabbixProtocol|FlagCompressed|FlagLargePacket) != 0 {
+		return h, fmt.Errorf("%w: 0x%02x", ErrUnknownFlag, h.Flags)
+	}
+
+	b = b[5:h.Size()]
+	if err := readFull(r, b); err != nil {
+		return h, fmt.Errorf("receiving data length: %w", err)
+	}
+
+	if h.Flags&FlagLargePacket != 0 {
+		h.DataLength = binary.LittleEndian.Uint64(b[:8])
+		h.Reserved = binary.LittleEndian.Uint64(b[8:])
+	} else {
+		h.DataLength = uint64(binary.LittleEndian.Uint32(b[:4]))
+		h.Reserved = uint64(binary.LittleEndian.Uint32(b[4:]))
+	}
+
+	return h, nil
+}
+
+// readFull read exactly len(buf) bytes from r.
+func readFull(r io.Reader, buf []byte) error {
+	_, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %v", ErrTruncatedFrame, err)
+	}
+	return err
+}
+
+// Encoder writes zabbix protocol frames to an output stream.
+type Encoder struct {
+	w io.Writer
+
+	// Compress enables zlib compression of the frames (flag 0x03).
+	Compress bool
+
+	// LargePackets allows frames bigger than 4 GiB using the large packet
+	// flag (0x04). Without it such frames fail with a PacketTooLargeError.
+	LargePackets bool
+}
+
+// NewEncoder return a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// header return the header of a frame with the given data length and
+// uncompressed length.
+func (e *Encoder) header(length, uncompressed uint64) Header {
+	h := Header{Flags: FlagZabbixProtocol, DataLength: length}
+	if e.Compress {
+		h.Flags |= FlagCompressed
+		h.Reserved = uncompressed
+	}
+	if e.LargePackets && (length > maxDataLen || uncompressed > maxDataLen) {
+		h.Flags |= FlagLargePacket
+	}
+	return h
+}
+
+// Encode write data as one frame, header and data in a single write.
+func (e *Encoder) Encode(data []byte) error {
+	if e.Compress {
+		return e.writeCompressed(data)
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.Write(data)
+	return e.write(buf, uint64(len(data)))
+}
+
+// EncodeJSON write the JSON encoding of v as one frame. v is marshaled only
+// once, into a pooled buffer, and header and data are sent in a single
+// write.
+func (e *Encoder) EncodeJSON(v interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	// Drop the newline added by json.Encoder
+	buf.Truncate(buf.Len() - 1)
+
+	if e.Compress {
+		return e.writeCompressed(buf.Bytes()[maxHeaderSize:])
+	}
+	return e.write(buf, uint64(buf.Len()-maxHeaderSize))
+}
+
+// writeCompressed write data compressed as one frame.
+func (e *Encoder) writeCompressed(data []byte) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	zw := zlibPool.Get().(*zlib.Writer)
+	defer zlibPool.Put(zw)
+	zw.Reset(buf)
+	if _, err := zw.Write(data); err != nil {
+		return fmt.Errorf("compressing data: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compressing data: %v", err)
+	}
+
+	return e.write(buf, uint64(len(data)))
+}
+
+// write the frame data held in buf after the reserved header space, putting
+// the header just before it.
+func (e *Encoder) write(buf *bytes.Buffer, uncompressed uint64) error {
+	b := buf.Bytes()
+	h := e.header(uint64(len(b)-maxHeaderSize), uncompressed)
+	if err := h.check(); err != nil {
+		return err
+	}
+
+	b = b[maxHeaderSize-h.Size():]
+	h.put(b)
+	_, err := e.w.Write(b)
+	return err
+}
+
+// Decoder reads zabbix protocol frames from an input stream.
+type Decoder struct {
+	r io.Reader
+
+	// MaxSize limits the size of the frame data, uncompressed. Zero means
+	// 16 MiB.
+	MaxSize uint64
+}
+
+// NewDecoder return a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode read the next frame and return its header and its data,
+// decompressed if needed.
+func (d *Decoder) Decode() (Header, []byte, error) {
+	h, err := ReadHeader(d.r)
+	if err != nil {
+		return h, nil, err
+	}
+
+	max := d.MaxSize
+	if max == 0 {
+		max = defaultMaxFrameSize
+	}
+	if h.DataLength > max || (h.Flags&FlagCompressed != 0 && h.Reserved > max) {
+		return h, nil, fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, h.DataLength, max)
+	}
+
+	data := make([]byte, h.DataLength)
+	if err := readFull(d.r, data); err != nil {
+		return h, nil, fmt.Errorf("receiving data: %w", err)
+	}
+
+	if h.Flags&FlagCompressed != 0 {
+		if data, err = decompress(data, h.Reserved); err != nil {
+			return h, nil, fmt.Errorf("decompressing data: %w", err)
+		}
+	}
+
+	return h, data, nil
+}
+
+// decompress return the zlib decompressed data of a frame, which must be
+// exactly length bytes long.
+func decompress(data []byte, length uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	defer zr.Close()
+
+	// Read one byte more than expected to detect longer data
+	res, err := ioutil.ReadAll(io.LimitReader(zr, int64(length)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	if uint64(len(res)) != length {
+		return nil, fmt.Errorf("%w: uncompressed %d bytes, header declares %d", ErrLengthMismatch, len(res), length)
+	}
+	return res, nil
+}
@@ -0,0 +1,195 @@
+package zabbix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestHeaderMarshal(t *testing.T) {
+	b, err := Header{Flags: FlagZabbixProtocol, DataLength: 10}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte("ZBXD\x01\x0a\x00\x00\x00\x00\x00\x00\x00")) {
+		t.Errorf("unexpected header %v", b)
+	}
+
+	_, err = Header{Flags: FlagZabbixProtocol, DataLength: maxDataLen + 1}.MarshalBinary()
+	var tooLarge *PacketTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected PacketTooLargeError, got %v", err)
+	}
+	if tooLarge.Size != maxDataLen+1 || tooLarge.Limit != maxDataLen {
+		t.Errorf("unexpected error values: %+v", tooLarge)
+	}
+
+	_, err = Header{Flags: 0x03, DataLength: 10, Reserved: maxDataLen + 1}.MarshalBinary()
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected PacketTooLargeError for uncompressed length, got %v", err)
+	}
+
+	b, err = Header{Flags: 0x05, DataLength: maxDataLen + 1, Reserved: 1}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("large packet should not fail: %v", err)
+	}
+	if len(b) != 21 || binary.LittleEndian.Uint64(b[5:]) != maxDataLen+1 || binary.LittleEndian.Uint64(b[13:]) != 1 {
+		t.Errorf("unexpected large packet header %v", b)
+	}
+
+	h, err := ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != (Header{Flags: 0x05, DataLength: maxDataLen + 1, Reserved: 1}) {
+		t.Errorf("unexpected header read %+v", h)
+	}
+}
+
+func TestEncoderHeader(t *testing.T) {
+	e := NewEncoder(nil)
+	if h := e.header(maxDataLen, maxDataLen); h.Flags != FlagZabbixProtocol || h.Reserved != 0 {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	e.Compress = true
+	if h := e.header(1024, maxDataLen+1); h.Flags != 0x03 || h.Reserved != maxDataLen+1 {
+		t.Errorf("unexpected compressed header %+v", h)
+	}
+
+	e.LargePackets = true
+	if h := e.header(1024, maxDataLen+1); h.Flags != 0x07 {
+		t.Errorf("expected flags 0x07, got 0x%02x", h.Flags)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	data := []byte(`{"request":"sender data","data":[{"host":"h","key":"k","value":"v"}]}`)
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Compress = compress
+		if err := enc.Encode(data); err != nil {
+			t.Fatal(err)
+		}
+
+		h, decoded, err := NewDecoder(&buf).Decode()
+		if err != nil {
+			t.Fatalf("decoding frame (compress=%v): %v", compress, err)
+		}
+		if (h.Flags&FlagCompressed != 0) != compress {
+			t.Errorf("unexpected flags 0x%02x (compress=%v)", h.Flags, compress)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("expected %s, got %s", data, decoded)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	body := `{"response":"success"}`
+	compressed := zlibCompress(t, []byte(body))
+
+	tests := []struct {
+		name     string
+		frame    []byte
+		expected error
+	}{
+		{"short header", []byte("ZBX"), ErrTruncatedFrame},
+		{"short data length", []byte("ZBXD\x01\x05\x00"), ErrTruncatedFrame},
+		{"short data", zabbixResponse(body)[:20], ErrTruncatedFrame},
+		{"bad magic", []byte("ZBXE\x01\x00\x00\x00\x00\x00\x00\x00\x00"), ErrBadMagic},
+		{"unknown flag", []byte("ZBXD\x09\x00\x00\x00\x00\x00\x00\x00\x00"), ErrUnknownFlag},
+		{"missing protocol flag", []byte("ZBXD\x02\x00\x00\x00\x00\x00\x00\x00\x00"), ErrUnknownFlag},
+		{"too large", []byte("ZBXD\x01\x00\x00\x00\x10\x00\x00\x00\x00"), ErrFrameTooLarge},
+		{
+			"uncompressed length mismatch",
+			frame(Header{Flags: 0x03, DataLength: uint64(len(compressed)), Reserved: uint64(len(body) - 1)}, compressed),
+			ErrLengthMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoder(bytes.NewReader(tt.frame))
+			dec.MaxSize = 1024
+			if _, _, err := dec.Decode(); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "<13>", false, 1600000000)}, false)
+	expected, _ := json.Marshal(packet)
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Compress = compress
+		if err := enc.EncodeJSON(packet); err != nil {
+			t.Fatal(err)
+		}
+
+		_, data, err := NewDecoder(&buf).Decode()
+		if err != nil {
+			t.Fatalf("decoding frame (compress=%v): %v", compress, err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("expected %s, got %s (compress=%v)", expected, data, compress)
+		}
+	}
+}
+
+func benchmarkEncodePacket(b *testing.B, metrics int, compress bool) {
+	var data []*Metric
+	for i := 0; i < metrics; i++ {
+		data = append(data, NewMetric("zabbixTrapper1", "key"+strconv.Itoa(i), "3.14159", false, 1600000000))
+	}
+	packet := NewPacket(data, false)
+
+	enc := NewEncoder(ioutil.Discard)
+	enc.Compress = compress
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.EncodeJSON(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N*metrics), "allocs/metric")
+}
+
+func BenchmarkEncodePacket1(b *testing.B)               { benchmarkEncodePacket(b, 1, false) }
+func BenchmarkEncodePacket100(b *testing.B)             { benchmarkEncodePacket(b, 100, false) }
+func BenchmarkEncodePacket10000(b *testing.B)           { benchmarkEncodePacket(b, 10000, false) }
+func BenchmarkEncodePacketCompressed100(b *testing.B)   { benchmarkEncodePacket(b, 100, true) }
+func BenchmarkEncodePacketCompressed10000(b *testing.B) { benchmarkEncodePacket(b, 10000, true) }
+
+func TestPacketDataLen(t *testing.T) {
+	packet := NewPacket([]*Metric{NewMetric("host", "key", "1", false)}, false)
+	data, err := json.Marshal(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]byte, 8)
+	binary.LittleEndian.PutUint32(expected, uint32(len(data)))
+	if got := packet.DataLen(); !bytes.Equal(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
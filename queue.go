@@ -0,0 +1,129 @@
+package zabbix
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// metricQueue is the queue of a BufferedSender. Metrics are kept in a ring
+// per priority, so dropping the oldest metric, or the oldest one of the
+// lowest priority, does not depend on the number of queued metrics.
+type metricQueue struct {
+	rings map[int]*metricRing
+	// prios holds the priorities of rings, lowest first
+	prios intHeap
+	seq   uint64
+	len   int
+}
+
+type queuedMetric struct {
+	metric *Metric
+	seq    uint64
+}
+
+// push add m at the end of the ring of priority prio.
+func (q *metricQueue) push(m *Metric, prio int) {
+	r, ok := q.rings[prio]
+	if !ok {
+		if q.rings == nil {
+			q.rings = make(map[int]*metricRing)
+		}
+		r = &metricRing{}
+		q.rings[prio] = r
+		heap.Push(&q.prios, prio)
+	}
+	q.seq++
+	r.push(queuedMetric{m, q.seq})
+	q.len++
+}
+
+// lowest return the lowest priority of the queued metrics, ok is false when
+// the queue is empty.
+func (q *metricQueue) lowest() (prio int, ok bool) {
+	for q.prios.Len() > 0 {
+		prio = q.prios[0]
+		if q.rings[prio].len > 0 {
+			return prio, true
+		}
+		// Forget the priorities without metrics left
+		heap.Pop(&q.prios)
+		delete(q.rings, prio)
+	}
+	return 0, false
+}
+
+// dropLowest remove the oldest of the metrics with the lowest priority.
+func (q *metricQueue) dropLowest() {
+	if prio, ok := q.lowest(); ok {
+		q.rings[prio].pop()
+		q.len--
+	}
+}
+
+// take return the queued metrics in the order they were pushed and empty the
+// queue.
+func (q *metricQueue) take() []*Metric {
+	if q.len == 0 {
+		return nil
+	}
+	queued := make([]queuedMetric, 0, q.len)
+	for _, r := range q.rings {
+		queued = r.appendTo(queued)
+	}
+	if len(q.rings) > 1 {
+		sort.Slice(queued, func(i, j int) bool { return queued[i].seq < queued[j].seq })
+	}
+
+	metrics := make([]*Metric, len(queued))
+	for i, qm := range queued {
+		metrics[i] = qm.metric
+	}
+	*q = metricQueue{}
+	return metrics
+}
+
+// metricRing is a FIFO of metrics growing as needed.
+type metricRing struct {
+	buf  []queuedMetric
+	head int
+	len  int
+}
+
+func (r *metricRing) push(qm queuedMetric) {
+	if r.len == len(r.buf) {
+		buf := make([]queuedMetric, 0, 2*len(r.buf)+1)
+		r.buf = r.appendTo(buf)[:cap(buf)]
+		r.head = 0
+	}
+	r.buf[(r.head+r.len)%len(r.buf)] = qm
+	r.len++
+}
+
+func (r *metricRing) pop() {
+	r.buf[r.head] = queuedMetric{}
+	r.head = (r.head + 1) % len(r.buf)
+	r.len--
+}
+
+// appendTo append the metrics of r to queued, oldest first.
+func (r *metricRing) appendTo(queued []queuedMetric) []queuedMetric {
+	for i := 0; i < r.len; i++ {
+		queued = append(queued, r.buf[(r.head+i)%len(r.buf)])
+	}
+	return queued
+}
+
+// intHeap is a min-heap of ints for container/heap.
+type intHeap []int
+
+func (h intHeap) Len() int           { return len(h) }
+func (h intHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x any)        { *h = append(*h, x.(int)) }
+
+func (h *intHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
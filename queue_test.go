@@ -0,0 +1,33 @@
+package zabbix
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMetricQueue(t *testing.T) {
+	var q metricQueue
+
+	// Priorities 0, 1 and 2 in turn, growing the rings a few times
+	for i := 0; i < 30; i++ {
+		q.push(NewMetric("zabbixTrapper1", "ping", strconv.Itoa(i), false), i%3)
+	}
+	// Drop the 10 metrics of priority 0, then the oldest of priority 1
+	for i := 0; i < 11; i++ {
+		q.dropLowest()
+	}
+	if prio, ok := q.lowest(); !ok || prio != 1 {
+		t.Errorf("expected lowest priority 1, got %d, %v", prio, ok)
+	}
+	if q.len != 19 {
+		t.Errorf("expected 19 metrics, got %d", q.len)
+	}
+
+	expected := "2,4,5,7,8,10,11,13,14,16,17,19,20,22,23,25,26,28,29"
+	if v := values(q.take()); v != expected {
+		t.Errorf("expected queue %s, got %s", expected, v)
+	}
+	if q.len != 0 || q.take() != nil {
+		t.Error("expected empty queue after take")
+	}
+}
@@ -0,0 +1,145 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+const defaultReceiverAddr = ":10051"
+
+// PacketHandler handles the packets received by a Receiver. The returned
+// Response is sent back to the client, an error is sent as a "failed"
+// response with the error message as info.
+type PacketHandler interface {
+	HandlePacket(ctx context.Context, packet *Packet) (Response, error)
+}
+
+// PacketHandlerFunc adapts a function to a PacketHandler.
+type PacketHandlerFunc func(ctx context.Context, packet *Packet) (Response, error)
+
+// HandlePacket call f(ctx, packet).
+func (f PacketHandlerFunc) HandlePacket(ctx context.Context, packet *Packet) (Response, error) {
+	return f(ctx, packet)
+}
+
+// MetricFunc is a PacketHandler handling the metrics of the packets one by
+// one. A metric is counted as failed when the function returns an error, as
+// processed otherwise.
+type MetricFunc func(ctx context.Context, metric *Metric) error
+
+// HandlePacket call f for each metric of packet and return a response with
+// the processed and failed counts.
+func (f MetricFunc) HandlePacket(ctx context.Context, packet *Packet) (Response, error) {
+	start := time.Now()
+	info := &ResponseInfo{Total: len(packet.Data)}
+	for _, m := range packet.Data {
+		if err := f(ctx, m); err != nil {
+			info.Failed++
+		} else {
+			info.Processed++
+		}
+	}
+	info.Spent = time.Since(start)
+
+	return Response{Response: "success", Info: info.String()}, nil
+}
+
+// Receiver acts as a Zabbix server or proxy trapper: it receives the "sender
+// data" and "agent data" packets sent by zabbix_sender, agents and Sender,
+// and reply with the Response of Handler. Other requests are rejected.
+type Receiver struct {
+	Handler PacketHandler
+
+	// Timeouts of the reading of a packet and the writing of the response,
+	// zero means no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MaxPacketSize limits the size of the packets accepted, uncompressed.
+	// Zero means 16 MiB.
+	MaxPacketSize uint64
+
+	srv server
+}
+
+// NewReceiver return a Receiver passing the packets to handler, using
+// default values for timeouts.
+func NewReceiver(handler PacketHandler) *Receiver {
+	return &Receiver{
+		Handler:      handler,
+		ReadTimeout:  defaultReadTimeout,
+		WriteTimeout: defaultWriteTimeout,
+	}
+}
+
+// ListenAndServe listen on the TCP address addr, ":10051" if empty, and
+// serve the connections. It return ErrServerClosed once the receiver is
+// closed.
+func (r *Receiver) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = defaultReceiverAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(l)
+}
+
+// Serve the connections accepted on l. It return ErrServerClosed once the
+// receiver is closed.
+func (r *Receiver) Serve(l net.Listener) error {
+	return r.srv.serve(l, r.handle)
+}
+
+// Close the listeners and the connections in progress.
+func (r *Receiver) Close() error {
+	return r.srv.close()
+}
+
+// response is the JSON encoding of a Response.
+type response struct {
+	Response string `json:"response"`
+	Info     string `json:"info,omitempty"`
+}
+
+// handle a connection of a client, one packet per connection.
+func (r *Receiver) handle(ctx context.Context, conn net.Conn) {
+	conn.SetReadDeadline(deadline(ctx, r.ReadTimeout))
+	dec := NewDecoder(conn)
+	dec.MaxSize = r.MaxPacketSize
+	h, data, err := dec.Decode()
+	if err != nil {
+		return
+	}
+
+	res := r.serve(ctx, data)
+
+	conn.SetWriteDeadline(deadline(ctx, r.WriteTimeout))
+	enc := NewEncoder(conn)
+	enc.Compress = h.Flags&FlagCompressed != 0
+	enc.EncodeJSON(&response{Response: res.Response, Info: res.Info})
+}
+
+// serve return the response to the request data.
+func (r *Receiver) serve(ctx context.Context, data []byte) Response {
+	var packet Packet
+	if err := json.Unmarshal(data, &packet); err != nil {
+		return Response{Response: "failed", Info: "cannot parse request: " + err.Error()}
+	}
+	if packet.Request != "sender data" && packet.Request != "agent data" {
+		return Response{Response: "failed", Info: fmt.Sprintf("unsupported request %q", packet.Request)}
+	}
+	for _, m := range packet.Data {
+		m.Active = packet.Request == "agent data"
+	}
+
+	res, err := r.Handler.HandlePacket(ctx, &packet)
+	if err != nil {
+		return Response{Response: "failed", Info: err.Error()}
+	}
+	return res
+}
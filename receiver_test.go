@@ -0,0 +1,90 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// startReceiver serve r on an ephemeral port and return its address.
+func startReceiver(t *testing.T, r *Receiver) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- r.Serve(listener) }()
+	t.Cleanup(func() {
+		r.Close()
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected Serve to return ErrServerClosed, got %v", err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+func TestReceiver(t *testing.T) {
+	var mu sync.Mutex
+	var received []*Metric
+	r := NewReceiver(MetricFunc(func(ctx context.Context, m *Metric) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, m)
+		if m.Value == "bad" {
+			return errors.New("invalid value")
+		}
+		return nil
+	}))
+	addr := startReceiver(t, r)
+
+	s := NewSender(addr)
+	s.Compress = true
+	res := s.SendBatch([]*Metric{
+		NewMetric("host", "trapper", "1", false, 1700000000),
+		NewMetric("host", "trapper", "bad", false),
+		NewMetric("host", "active", "2", true),
+	})
+	if res.Err != nil {
+		t.Fatalf("error sending metrics: %v", res.Err)
+	}
+	if res.Processed() != 2 || res.Failed() != 1 || len(res.Packets) != 2 {
+		t.Errorf("unexpected result processed=%d failed=%d packets=%d", res.Processed(), res.Failed(), len(res.Packets))
+	}
+	if info := res.Packets[0].Info; info.Total != 2 || info.Failed != 1 {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	if len(received) != 3 {
+		t.Fatalf("expected 3 metrics, got %d", len(received))
+	}
+	if m := received[0]; m.Host != "host" || m.Key != "trapper" || m.Value != "1" || m.Clock != 1700000000 || m.Active {
+		t.Errorf("unexpected metric %+v", m)
+	}
+	if !received[2].Active {
+		t.Errorf("expected agent data metric to be active")
+	}
+}
+
+func TestReceiverErrors(t *testing.T) {
+	r := NewReceiver(PacketHandlerFunc(func(ctx context.Context, packet *Packet) (Response, error) {
+		return Response{}, errors.New("storage unavailable")
+	}))
+	addr := startReceiver(t, r)
+
+	s := NewSender(addr)
+	s.Strict = true
+	_, err := s.Send(NewPacket([]*Metric{NewMetric("host", "key", "1", false)}, false))
+	var resErr *ResponseError
+	if !errors.As(err, &resErr) || resErr.Response.Response != "failed" || resErr.Response.Info != "storage unavailable" {
+		t.Errorf("expected failed response, got %v", err)
+	}
+
+	_, _, err = s.GetActiveChecks("host", "")
+	if !errors.As(err, &resErr) || resErr.Response.Info != `unsupported request "active checks"` {
+		t.Errorf("expected unsupported request response, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultRelayQueueSize = 1000
+
+var (
+	// ErrRelayClosed is returned when relaying packets with a closed Relay.
+	ErrRelayClosed = errors.New("zabbix: relay closed")
+
+	// ErrRelayQueueFull is reported to RelayConfig.OnError for the packets
+	// dropped because the queue of a secondary upstream is full.
+	ErrRelayQueueFull = errors.New("zabbix: relay queue full, packet dropped")
+)
+
+// RelayConfig configures a Relay.
+type RelayConfig struct {
+	// QueueSize is the number of packets queued for each secondary
+	// upstream, the packets relayed while its queue is full are dropped.
+	// Zero means 1000.
+	QueueSize int
+
+	// Retry, if set, makes the relay send a queued packet again when it
+	// fails with a retryable error, as Sender.Retry does. It is independent
+	// of the Retry policy of the upstream Sender, applied to each attempt.
+	Retry *RetryPolicy
+
+	// RetryForever makes the relay ignore Retry.MaxAttempts, sending a
+	// queued packet again until it succeeds or the relay is closed. It has
+	// no effect without Retry.
+	RetryForever bool
+
+	// OnError, if set, is called with the errors of the secondary
+	// upstreams, the send errors and ErrRelayQueueFull. It should not block.
+	OnError func(upstream *Sender, err error)
+}
+
+// Relay is a PacketHandler forwarding the packets received by a Receiver to
+// several servers. Packets are sent to the primary upstream synchronously,
+// and the client gets its response. They are queued for each secondary
+// upstream, and sent in order from a goroutine per upstream, so a slow or
+// unavailable secondary does not delay the others.
+type Relay struct {
+	primary   *Sender
+	upstreams []*upstream
+	config    RelayConfig
+
+	// mu protects closed and the sends on the queues
+	mu     sync.Mutex
+	closed bool
+
+	wg sync.WaitGroup
+
+	// ctx is canceled when Close gives up waiting for the queues
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// upstream is a secondary upstream of a Relay.
+type upstream struct {
+	sender *Sender
+	queue  chan *Packet
+}
+
+// NewRelay return a Relay forwarding to primary and secondaries, and start
+// the goroutines sending to the secondaries. Close must be called to stop
+// them.
+func NewRelay(primary *Sender, secondaries []*Sender, config RelayConfig) *Relay {
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultRelayQueueSize
+	}
+
+	r := &Relay{primary: primary, config: config}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	for _, s := range secondaries {
+		u := &upstream{sender: s, queue: make(chan *Packet, config.QueueSize)}
+		r.upstreams = append(r.upstreams, u)
+		r.wg.Add(1)
+		go r.run(u)
+	}
+	return r
+}
+
+// HandlePacket queue packet for the secondary upstreams, send it to the
+// primary and return its response.
+//
+// The queued packets may be sent long after they were received, so their
+// clock is not kept: the metrics get the time they were received, corrected
+// by the difference between the clocks of the client and the relay. Set
+// StampPackets on the secondary upstreams to have the server correct the
+// relay clock too.
+func (r *Relay) HandlePacket(ctx context.Context, packet *Packet) (Response, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return Response{}, ErrRelayClosed
+	}
+	for _, u := range r.upstreams {
+		select {
+		case u.queue <- receivedPacket(packet, now):
+		default:
+			r.error(u.sender, ErrRelayQueueFull)
+		}
+	}
+	r.mu.Unlock()
+
+	return r.primary.SendContext(ctx, packet)
+}
+
+// Close stop relaying packets and wait until the queued ones are sent or
+// ctx is done, then stop the goroutines sending them. The packets still
+// queued are lost.
+func (r *Relay) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrRelayClosed
+	}
+	r.closed = true
+	for _, u := range r.upstreams {
+		close(u.queue)
+	}
+	r.mu.Unlock()
+
+	defer r.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// run send the packets queued for u, until its queue is closed.
+func (r *Relay) run(u *upstream) {
+	defer r.wg.Done()
+	for packet := range u.queue {
+		if r.ctx.Err() == nil {
+			r.forward(u, packet)
+		}
+	}
+}
+
+// forward send packet to u, sending it again after the retryable errors.
+// Spooled packets are not retried, the upstream Sender replays them.
+func (r *Relay) forward(u *upstream, packet *Packet) {
+	for n := 1; ; n++ {
+		_, err := u.sender.SendContext(r.ctx, packet)
+		if err == nil || r.ctx.Err() != nil {
+			return
+		}
+		r.error(u.sender, err)
+
+		retry := r.config.Retry
+		if retry == nil || errors.Is(err, ErrSpooled) || !retry.retryable(err) || (!r.config.RetryForever && n >= retry.MaxAttempts) {
+			return
+		}
+
+		timer := time.NewTimer(retry.backoff(n))
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// error report err of upstream s to OnError.
+func (r *Relay) error(s *Sender, err error) {
+	if r.config.OnError != nil {
+		r.config.OnError(s, err)
+	}
+}
+
+// receivedPacket return a copy of packet and its metrics, so upstreams do
+// not share them, with the times of the metrics set for packet received at
+// now, and without packet clock.
+func receivedPacket(packet *Packet, now time.Time) *Packet {
+	// The server corrects the time of the metrics by the difference between
+	// its clock and the packet one, do it with the relay clock
+	var offset time.Duration
+	if packet.Clock != 0 {
+		offset = now.Sub(time.Unix(packet.Clock, int64(packet.Ns)))
+	}
+
+	p := *packet
+	p.Clock, p.Ns = 0, 0
+	p.Data = make([]*Metric, len(packet.Data))
+	for i, m := range packet.Data {
+		metric := *m
+		switch {
+		case metric.Clock == 0:
+			metric.Clock, metric.Ns, metric.exactNs = now.Unix(), now.Nanosecond(), true
+		case offset != 0:
+			t := time.Unix(metric.Clock, int64(metric.Ns)).Add(offset)
+			metric.Clock, metric.Ns = t.Unix(), t.Nanosecond()
+		}
+		p.Data[i] = &metric
+	}
+	return &p
+}
@@ -0,0 +1,148 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	// The primary fails one of the values
+	primary := NewReceiver(MetricFunc(func(ctx context.Context, m *Metric) error {
+		if m.Value == "bad" {
+			return errors.New("invalid value")
+		}
+		return nil
+	}))
+	primaryAddr := startReceiver(t, primary)
+
+	// The secondary drops the first connection without a response
+	var packets []Packet
+	secondaryAddr, errs := fakeZabbix(t, 2, func(header, data []byte) []byte {
+		var packet Packet
+		if err := json.Unmarshal(data, &packet); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		packets = append(packets, packet)
+		if len(packets) == 1 {
+			return nil
+		}
+		return zabbixResponse(`{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"}`)
+	})
+
+	var mu sync.Mutex
+	var upstreamErrs []error
+	secondary := NewSender(secondaryAddr)
+	relay := NewRelay(NewSender(primaryAddr), []*Sender{secondary}, RelayConfig{
+		Retry: &RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond},
+		OnError: func(s *Sender, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if s != secondary {
+				t.Errorf("unexpected upstream %s", s.Host)
+			}
+			upstreamErrs = append(upstreamErrs, err)
+		},
+	})
+	addr := startReceiver(t, NewReceiver(relay))
+
+	// The log items metadata is relayed too
+	logMetric := NewMetric("host", "eventlog[System]", "1", true, 1700000000)
+	logMetric.LastLogSize, logMetric.MTime = 42, 1699999999
+	logMetric.Timestamp, logMetric.Source, logMetric.Severity, logMetric.EventID = 1699999990, "disk", 4, 7
+
+	start := time.Now()
+	s := NewSender(addr)
+	res, err := s.Send(NewPacket([]*Metric{
+		logMetric,
+		NewMetric("host", "key", "bad", true),
+	}, true, 1700000001))
+	if err != nil {
+		t.Fatalf("error sending packet: %v", err)
+	}
+	info, err := res.GetInfo()
+	if err != nil || info.Processed != 1 || info.Failed != 1 {
+		t.Errorf("expected the primary response, got %+v, %v", res, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := relay.Close(ctx); err != nil {
+		t.Fatalf("error closing relay: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	end := time.Now()
+
+	if len(packets) != 2 {
+		t.Fatalf("expected the packet to be sent twice to the secondary, got %d", len(packets))
+	}
+	p := packets[1]
+	if p.Request != "agent data" || p.Clock != 0 || p.Ns != 0 || len(p.Data) != 2 {
+		t.Fatalf("expected the relayed packet without clock, got %+v", p)
+	}
+
+	// The values get the time they were received, minus their age on the
+	// client clock
+	valueTime := time.Unix(p.Data[0].Clock, int64(p.Data[0].Ns))
+	if valueTime.Before(start.Add(-time.Second)) || valueTime.After(end.Add(-time.Second)) {
+		t.Errorf("expected the value time between %v and %v, got %v", start.Add(-time.Second), end.Add(-time.Second), valueTime)
+	}
+	valueTime = time.Unix(p.Data[1].Clock, int64(p.Data[1].Ns))
+	if valueTime.Before(start) || valueTime.After(end) {
+		t.Errorf("expected the value without clock to get the receive time, got %v", valueTime)
+	}
+
+	// Active is not encoded, the request tells it
+	expected := *logMetric
+	expected.Active = false
+	expected.Clock, expected.Ns, expected.exactNs = p.Data[0].Clock, p.Data[0].Ns, p.Data[0].exactNs
+	if *p.Data[0] != expected {
+		t.Errorf("expected relayed metric %+v, got %+v", expected, p.Data[0])
+	}
+	if len(upstreamErrs) != 1 || !errors.Is(upstreamErrs[0], ErrTruncatedFrame) {
+		t.Errorf("expected the first send error to be reported, got %v", upstreamErrs)
+	}
+
+	if _, err := relay.HandlePacket(context.Background(), NewPacket(nil, false)); !errors.Is(err, ErrRelayClosed) {
+		t.Errorf("expected relay closed error, got %v", err)
+	}
+}
+
+func TestRelayRetryForever(t *testing.T) {
+	// The secondary drops the first connections without a response
+	calls := 0
+	secondaryAddr, errs := fakeZabbix(t, 4, func(header, data []byte) []byte {
+		if calls++; calls < 4 {
+			return nil
+		}
+		return zabbixResponse(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+	})
+	primaryAddr := startReceiver(t, NewReceiver(MetricFunc(func(ctx context.Context, m *Metric) error { return nil })))
+
+	// MaxAttempts is ignored, the packet is sent until it succeeds
+	relay := NewRelay(NewSender(primaryAddr), []*Sender{NewSender(secondaryAddr)}, RelayConfig{
+		Retry:        &RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond},
+		RetryForever: true,
+	})
+	if _, err := relay.HandlePacket(context.Background(), NewPacket([]*Metric{NewMetric("host", "key", "1", false)}, false)); err != nil {
+		t.Fatalf("error relaying packet: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := relay.Close(ctx); err != nil {
+		t.Fatalf("error closing relay: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("expected the packet to be sent 4 times, got %d", calls)
+	}
+}
@@ -0,0 +1,100 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// PacketResult is the outcome of sending one packet of metrics.
+type PacketResult struct {
+	// Request is the packet request, "sender data" or "agent data".
+	Request string
+	// Metrics carried by the packet.
+	Metrics []*Metric
+	// Response of the server, empty if the packet could not be sent.
+	Response Response
+	// Info parsed from the response, nil when the packet was not
+	// processed by the server.
+	Info *ResponseInfo
+	// Err is the error sending the packet or parsing its response.
+	Err error
+}
+
+// BatchResult is the outcome of SendBatch, with one PacketResult per sent
+// packet, in the order they were built.
+type BatchResult struct {
+	Packets []*PacketResult
+	// Err joins the errors of all the packets, prefixed with their request.
+	// It is nil when every packet succeeded.
+	Err error
+}
+
+// Info return the added up info of all the processed packets.
+func (r *BatchResult) Info() *ResponseInfo {
+	total := new(ResponseInfo)
+	for _, p := range r.Packets {
+		if p.Info != nil {
+			total.add(p.Info)
+		}
+	}
+	return total
+}
+
+// Processed return the number of values processed by the server.
+func (r *BatchResult) Processed() int {
+	var n int
+	for _, p := range r.Packets {
+		if p.Info != nil {
+			n += p.Info.Processed
+		}
+	}
+	return n
+}
+
+// Failed return the number of values the server failed to process.
+func (r *BatchResult) Failed() int {
+	var n int
+	for _, p := range r.Packets {
+		if p.Info != nil {
+			n += p.Info.Failed
+		}
+	}
+	return n
+}
+
+// SendBatch send an array of metrics, making different packets for trapper
+// and active items, split according to the Sender packet limits, and return
+// the outcome of all of them.
+func (s *Sender) SendBatch(metrics []*Metric) *BatchResult {
+	return s.SendBatchContext(context.Background(), metrics)
+}
+
+// SendBatchContext is like SendBatch but ctx can cancel the sending.
+func (s *Sender) SendBatchContext(ctx context.Context, metrics []*Metric) *BatchResult {
+	res := new(BatchResult)
+	trapperMetrics, activeMetrics := splitMetrics(metrics)
+
+	var errs []error
+	for _, packet := range []*Packet{NewPacket(trapperMetrics, false), NewPacket(activeMetrics, true)} {
+		if len(packet.Data) == 0 {
+			continue
+		}
+
+		for _, pr := range s.sendChunks(ctx, packet) {
+			var partial *PartialFailureError
+			if pr.Err == nil {
+				pr.Info, pr.Err = pr.Response.GetInfo()
+			} else if errors.As(pr.Err, &partial) {
+				pr.Info = partial.Info
+			}
+			if pr.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", pr.Request, pr.Err))
+			}
+			res.Packets = append(res.Packets, pr)
+		}
+	}
+	res.Err = errors.Join(errs...)
+
+	return res
+}
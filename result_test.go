@@ -0,0 +1,57 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSendBatch(t *testing.T) {
+	addr, errs := fakeZabbix(t, 2, func(header, data []byte) []byte {
+		var request ZabbixRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+
+		if request.Request == "agent data" {
+			return zabbixResponse(`{"response":"failed","info":"host [zabbixAgent1] not found"}`)
+		}
+		return zabbixResponse(`{"response":"success","info":"processed: 1; failed: 1; total: 2; seconds spent: 0.000030"}`)
+	})
+
+	metrics := []*Metric{
+		NewMetric("zabbixAgent1", "ping", "13", true),
+		NewMetric("zabbixTrapper1", "ping", "13", false),
+		NewMetric("zabbixTrapper1", "pong", "13", false),
+	}
+
+	s := NewSender(addr)
+	res := s.SendBatch(metrics)
+
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	if len(res.Packets) != 2 {
+		t.Fatalf("expected 2 packet results, got %d", len(res.Packets))
+	}
+
+	trapper, active := res.Packets[0], res.Packets[1]
+	if trapper.Request != "sender data" || len(trapper.Metrics) != 2 || trapper.Err != nil || trapper.Info == nil {
+		t.Errorf("unexpected trapper result: %+v", trapper)
+	}
+	if active.Request != "agent data" || len(active.Metrics) != 1 || active.Err == nil || active.Response.Response != "failed" {
+		t.Errorf("unexpected active result: %+v", active)
+	}
+
+	if res.Processed() != 1 {
+		t.Errorf("Processed error expected 1 got %d", res.Processed())
+	}
+	if res.Failed() != 1 {
+		t.Errorf("Failed error expected 1 got %d", res.Failed())
+	}
+
+	if res.Err == nil || !strings.HasPrefix(res.Err.Error(), "agent data: ") {
+		t.Errorf("expected the error to name the agent data packet, got %v", res.Err)
+	}
+}
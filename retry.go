@@ -0,0 +1,140 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultBaseBackoff = 100 * time.Millisecond
+	defaultMaxBackoff  = 10 * time.Second
+)
+
+// RetryPolicy configures how a Sender retries failed packets.
+//
+// Retrying after a write or read error may send again values the server
+// already stored.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first
+	// one. Values below 2 disable retries.
+	MaxAttempts int
+
+	// BaseBackoff is the wait before the first retry, doubled for each next
+	// retry up to MaxBackoff. Zero means 100ms and 10s.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Jitter is the fraction of the backoff which is randomized, between 0
+	// and 1: 0.2 waits between 80% and 120% of the backoff.
+	Jitter float64
+
+	// Retryable report if an error is worth a retry. Nil means
+	// DefaultRetryable.
+	Retryable func(error) bool
+}
+
+// Attempt describes an attempt to send a packet.
+type Attempt struct {
+	Packet *Packet
+	// Number of the attempt, starting at 1.
+	Number   int
+	Err      error
+	Duration time.Duration
+	// Retry reports if another attempt follows, after Backoff.
+	Retry   bool
+	Backoff time.Duration
+}
+
+// DefaultRetryable report network errors, such as a refused connection or a
+// timeout, as retryable. Errors reported by the server, invalid responses
+// and context errors are not.
+func DefaultRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var tooLarge *PacketTooLargeError
+	if errors.As(err, &tooLarge) {
+		return false
+	}
+
+	var opErr *OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+
+	// A connection closed before the response is a network error, other
+	// invalid frames will not get better
+	if errors.Is(err, ErrProtocol) && !errors.Is(err, ErrTruncatedFrame) {
+		return false
+	}
+	return true
+}
+
+// retryable report if err is retryable according to the policy.
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return DefaultRetryable(err)
+}
+
+// backoff return the wait after the given failed attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := p.BaseBackoff, p.MaxBackoff
+	if base <= 0 {
+		base = defaultBaseBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// retry call send until it succeeds or the Retry policy gives up, reporting
+// every attempt to OnAttempt. The result of the last attempt is returned.
+func (s *Sender) retry(ctx context.Context, packet *Packet, send func(context.Context, *Packet) (Response, error)) (Response, error) {
+	attempts := 1
+	if s.Retry != nil && s.Retry.MaxAttempts > 1 {
+		attempts = s.Retry.MaxAttempts
+	}
+
+	for n := 1; ; n++ {
+		start := time.Now()
+		res, err := send(ctx, packet)
+
+		a := Attempt{Packet: packet, Number: n, Err: err, Duration: time.Since(start)}
+		if err != nil && n < attempts && s.Retry.retryable(err) {
+			a.Retry = true
+			a.Backoff = s.Retry.backoff(n)
+		}
+		if s.OnAttempt != nil {
+			s.OnAttempt(a)
+		}
+		if !a.Retry {
+			return res, err
+		}
+
+		timer := time.NewTimer(a.Backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDefaultRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&OpError{Op: OpConnect, Err: errors.New("connection refused")}, true},
+		{&OpError{Op: OpWrite, Err: errors.New("broken pipe")}, true},
+		{&OpError{Op: OpRead, Err: fmt.Errorf("receiving header: %w", ErrTruncatedFrame)}, true},
+		{&OpError{Op: OpRead, Err: ErrBadMagic}, false},
+		{&OpError{Op: OpConnect, Err: context.Canceled}, false},
+		{&OpError{Op: OpWrite, Err: &PacketTooLargeError{Size: 1, Limit: 0}}, false},
+		{&ResponseError{Response: Response{Response: "failed"}}, false},
+		{&PartialFailureError{Info: &ResponseInfo{Failed: 1}}, false},
+		{fmt.Errorf("%w: %w", ErrInvalidResponse, errors.New("bad json")), false},
+	}
+
+	for _, test := range tests {
+		if got := DefaultRetryable(test.err); got != test.want {
+			t.Errorf("DefaultRetryable(%v) = %v, expected %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if got := p.backoff(attempt + 1); got != want*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, expected %v", attempt+1, got, want*time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(2); got < 10*time.Millisecond || got > 30*time.Millisecond {
+			t.Fatalf("backoff(2) with jitter = %v, expected between 10ms and 30ms", got)
+		}
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	// The first connection is closed without a response
+	calls := 0
+	addr, errs := fakeZabbix(t, 2, func(header, data []byte) []byte {
+		calls++
+		if calls == 1 {
+			return nil
+		}
+		return zabbixResponse(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+	})
+
+	var attempts []Attempt
+	s := NewSender(addr)
+	s.Retry = &RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond}
+	s.OnAttempt = func(a Attempt) { attempts = append(attempts, a) }
+
+	if _, err := s.Send(NewPacket([]*Metric{NewMetric("host", "key", "1", false)}, false)); err != nil {
+		t.Fatalf("error sending packet: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(attempts))
+	}
+	if !errors.Is(attempts[0].Err, ErrTruncatedFrame) || !attempts[0].Retry || attempts[0].Backoff != time.Millisecond {
+		t.Errorf("unexpected first attempt %+v", attempts[0])
+	}
+	if attempts[1].Number != 2 || attempts[1].Err != nil || attempts[1].Retry {
+		t.Errorf("unexpected second attempt %+v", attempts[1])
+	}
+}
+
+func TestSendRetryGiveUp(t *testing.T) {
+	// Get a free port with nothing listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	attempts := 0
+	s := NewSender(addr)
+	s.Retry = &RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond}
+	s.OnAttempt = func(a Attempt) { attempts++ }
+
+	_, err = s.Send(NewPacket([]*Metric{NewMetric("host", "key", "1", false)}, false))
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != OpConnect {
+		t.Errorf("expected a connect error, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+
+	// Server rejections are not retried
+	attempts = 0
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		return zabbixResponse(`{"response":"success","info":"processed: 0; failed: 1; total: 1; seconds spent: 0.000030"}`)
+	})
+	s.Host = addr
+	s.Strict = true
+	if _, err = s.Send(NewPacket([]*Metric{NewMetric("host", "key", "1", false)}, false)); !errors.Is(err, ErrPartialFailure) {
+		t.Errorf("expected partial failure error, got %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+}
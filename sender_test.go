@@ -0,0 +1,226 @@
+package zabbix_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	zabbix "github.com/spetr/go-zabbix-sender"
+	"github.com/spetr/go-zabbix-sender/zabbixtest"
+)
+
+func TestSendActiveMetric(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	m := zabbix.NewMetric("zabbixAgent1", "ping", "13", true)
+
+	s := zabbix.NewSender(srv.Addr)
+	resActive, errActive, resTrapper, errTrapper := s.SendMetrics([]*zabbix.Metric{m})
+	if errActive != nil {
+		t.Fatalf("error sending active metric: %v", errActive)
+	}
+	if errTrapper != nil {
+		t.Fatalf("trapper error should be nil, we are not sending trapper metrics: %v", errTrapper)
+	}
+
+	raInfo, err := resActive.GetInfo()
+	if err != nil {
+		t.Fatalf("error in response Trapper: %v", err)
+	}
+
+	if raInfo.Failed != 0 {
+		t.Errorf("Failed error expected 0 got %d", raInfo.Failed)
+	}
+	if raInfo.Processed != 1 {
+		t.Errorf("Processed error expected 1 got %d", raInfo.Processed)
+	}
+	if raInfo.Total != 1 {
+		t.Errorf("Total error expected 1 got %d", raInfo.Total)
+	}
+
+	_, err = resTrapper.GetInfo()
+	if err == nil {
+		t.Fatalf("No response trapper expected: %v", err)
+	}
+
+	srv.AssertRequests(t, "agent data")
+	srv.AssertMetric(t, "zabbixAgent1", "ping", "13")
+}
+
+func TestSendTrapperMetric(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	m := zabbix.NewMetric("zabbixAgent1", "ping", "13", false)
+
+	s := zabbix.NewSender(srv.Addr)
+	resActive, errActive, resTrapper, errTrapper := s.SendMetrics([]*zabbix.Metric{m})
+	if errTrapper != nil {
+		t.Fatalf("error sending trapper metric: %v", errTrapper)
+	}
+	if errActive != nil {
+		t.Fatalf("active error should be nil, we are not sending zabbix agent metrics: %v", errActive)
+	}
+
+	rtInfo, err := resTrapper.GetInfo()
+	if err != nil {
+		t.Fatalf("error in response Trapper: %v", err)
+	}
+
+	if rtInfo.Failed != 0 {
+		t.Errorf("Failed error expected 0 got %d", rtInfo.Failed)
+	}
+	if rtInfo.Processed != 1 {
+		t.Errorf("Processed error expected 1 got %d", rtInfo.Processed)
+	}
+	if rtInfo.Total != 1 {
+		t.Errorf("Total error expected 1 got %d", rtInfo.Total)
+	}
+
+	_, err = resActive.GetInfo()
+	if err == nil {
+		t.Fatalf("No response active expected: %v", err)
+	}
+
+	srv.AssertRequests(t, "sender data")
+	srv.AssertMetric(t, "zabbixAgent1", "ping", "13")
+}
+
+func TestSendActiveAndTrapperMetric(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	s := zabbix.NewSender(srv.Addr)
+	resActive, errActive, resTrapper, errTrapper := s.SendMetrics([]*zabbix.Metric{
+		zabbix.NewMetric("zabbixAgent1", "ping", "13", true),
+		zabbix.NewMetric("zabbixTrapper1", "pong", "13", false),
+	})
+	if errActive != nil {
+		t.Fatalf("error sending active metric: %v", errActive)
+	}
+	if errTrapper != nil {
+		t.Fatalf("error sending trapper metric: %v", errTrapper)
+	}
+
+	raInfo, err := resActive.GetInfo()
+	if err != nil {
+		t.Fatalf("error in response Trapper: %v", err)
+	}
+
+	if raInfo.Failed != 0 {
+		t.Errorf("Failed error expected 0 got %d", raInfo.Failed)
+	}
+	if raInfo.Processed != 1 {
+		t.Errorf("Processed error expected 1 got %d", raInfo.Processed)
+	}
+	if raInfo.Total != 1 {
+		t.Errorf("Total error expected 1 got %d", raInfo.Total)
+	}
+
+	rtInfo, err := resTrapper.GetInfo()
+	if err != nil {
+		t.Fatalf("error in response Trapper: %v", err)
+	}
+
+	if rtInfo.Failed != 0 {
+		t.Errorf("Failed error expected 0 got %d", rtInfo.Failed)
+	}
+	if rtInfo.Processed != 1 {
+		t.Errorf("Processed error expected 1 got %d", rtInfo.Processed)
+	}
+	if rtInfo.Total != 1 {
+		t.Errorf("Total error expected 1 got %d", rtInfo.Total)
+	}
+
+	srv.AssertRequests(t, "sender data", "agent data")
+	srv.AssertMetricCount(t, 2)
+}
+
+func TestRegisterHostOK(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	// If the host does not exist, the first response will be an error,
+	// the next one is the valid one
+	srv.Respond("active checks",
+		zabbixtest.Reply{Response: "failed", Info: "host [prueba] not found"},
+		zabbixtest.Reply{Data: []zabbix.ActiveCheck{{Key: "net.if.in[eth0]", Delay: "60"}}},
+	)
+
+	s := zabbix.NewSender(srv.Addr)
+	err := s.RegisterHost("prueba", "prueba")
+	if err != nil {
+		t.Fatalf("register host error: %v", err)
+	}
+
+	srv.AssertRequests(t, "active checks", "active checks")
+	if p := srv.Packets()[0]; p.Host != "prueba" || p.HostMetadata != "prueba" {
+		t.Errorf("unexpected host %q and metadata %q", p.Host, p.HostMetadata)
+	}
+}
+
+func TestRegisterHostError(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	// Simulate error always
+	failed := zabbixtest.Reply{Response: "failed", Info: "host [prueba] not found"}
+	srv.Respond("active checks", failed, failed)
+
+	s := zabbix.NewSender(srv.Addr)
+	err := s.RegisterHost("prueba", "prueba")
+	if err == nil {
+		t.Fatalf("should return an error: %v", err)
+	}
+
+	srv.AssertRequests(t, "active checks", "active checks")
+}
+
+func TestInvalidResponseHeader(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+	srv.Respond("agent data", zabbixtest.Reply{
+		Raw: []byte("BXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"info\":\"processed: 1; failed: 0; total: 1; seconds spent: 0.000030\"}"),
+	})
+
+	m := zabbix.NewMetric("zabbixAgent1", "ping", "13", true)
+
+	s := zabbix.NewSender(srv.Addr)
+	_, errActive, _, _ := s.SendMetrics([]*zabbix.Metric{m})
+	if errActive == nil {
+		t.Fatal("Expected an error because an incorrect Zabbix protocol header")
+	}
+
+	srv.AssertRequests(t, "agent data")
+}
+
+func TestSendMetricsStampedPacketSize(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	var metrics []*zabbix.Metric
+	for i := 0; i < 40; i++ {
+		metrics = append(metrics, zabbix.NewMetric("zabbixTrapper1", fmt.Sprintf("key%d", i), "13", false))
+	}
+
+	s := zabbix.NewSender(srv.Addr)
+	s.MaxPacketSize = 1000
+	s.StampMetrics = true
+	s.StampPackets = true
+	if _, _, _, err := s.SendMetrics(metrics); err != nil {
+		t.Fatalf("error sending metrics: %v", err)
+	}
+
+	// The clock of the metrics counts in the size of the packets
+	for _, p := range srv.Packets() {
+		data, _ := json.Marshal(p)
+		if len(data) > s.MaxPacketSize {
+			t.Errorf("packet of %d bytes exceeds the limit of %d", len(data), s.MaxPacketSize)
+		}
+		if p.Data[0].Clock == 0 {
+			t.Error("expected stamped metrics")
+		}
+	}
+	srv.AssertMetricCount(t, len(metrics))
+}
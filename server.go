@@ -0,0 +1,157 @@
+package zabbix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by the Serve and ListenAndServe methods after
+// the server was closed.
+var ErrServerClosed = errors.New("zabbix: server closed")
+
+// server accepts connections and handles each in its own goroutine, until
+// it is closed. It is embedded in the types serving the zabbix protocol.
+type server struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// serve accept the connections of l and call handle for each of them, in
+// its own goroutine, until l fails or the server is closed. Temporary
+// accept errors, as when running out of file descriptors, are retried after
+// a delay. The connections are closed when handle returns, and ctx is done
+// when serve returns.
+func (s *server) serve(l net.Listener, handle func(ctx context.Context, conn net.Conn)) error {
+	if !s.track(l, nil) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(nil, conn)
+			handle(ctx, conn)
+		}()
+	}
+}
+
+// track add l or conn to those closed with the server, and report false if
+// it is already closed.
+func (s *server) track(l net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	}
+	if conn != nil {
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+	}
+	return true
+}
+
+// untrack remove l or conn, closing conn.
+func (s *server) untrack(l net.Listener, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l != nil {
+		delete(s.listeners, l)
+	}
+	if conn != nil {
+		conn.Close()
+		delete(s.conns, conn)
+		s.wg.Done()
+	}
+}
+
+func (s *server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// close the listeners and the connections, and wait for their handlers to
+// return.
+func (s *server) close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if lerr := l.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// readRequest read a request frame from r. Requests without header, sent by
+// legacy clients, end with a newline which is removed.
+func readRequest(r *bufio.Reader, maxSize uint64) ([]byte, error) {
+	if b, err := r.Peek(1); err != nil || b[0] == headerMagic[0] {
+		if b, err := r.Peek(len(headerMagic)); err == nil && string(b) == headerMagic {
+			dec := NewDecoder(r)
+			dec.MaxSize = maxSize
+			_, data, err := dec.Decode()
+			return data, err
+		}
+	}
+
+	line, err := r.ReadSlice('\n')
+	if err != nil && (len(line) == 0 || err == bufio.ErrBufferFull) {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	return append([]byte(nil), line...), nil
+}
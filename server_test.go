@@ -0,0 +1,69 @@
+package zabbix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// temporaryError is a net.Error reported as temporary.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary accept error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails the first accepts with temporary errors, then with
+// err once it is set.
+type flakyListener struct {
+	net.Listener
+	failures int
+	err      error
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestServerAcceptErrors(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &flakyListener{Listener: inner, failures: 3}
+
+	var srv server
+	handled := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.serve(l, func(ctx context.Context, conn net.Conn) { close(handled) })
+	}()
+
+	// The connection is accepted after the temporary errors
+	conn, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	<-handled
+
+	srv.close()
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+
+	// Other errors stop serving
+	permanent := errors.New("permanent accept error")
+	l = &flakyListener{Listener: inner, err: permanent}
+	if err := new(server).serve(l, nil); err != permanent {
+		t.Errorf("expected the accept error, got %v", err)
+	}
+}
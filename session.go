@@ -0,0 +1,67 @@
+package zabbix
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// Session return the session token of the Sender, generated randomly on
+// first use. It is set on "agent data" packets when UseSession is enabled.
+func (s *Sender) Session() string {
+	s.sessionOnce.Do(func() {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic("zabbix: generating session token: " + err.Error())
+		}
+		s.session = hex.EncodeToString(b)
+		s.sessionSem = make(chan struct{}, 1)
+	})
+	return s.session
+}
+
+// identify return packet with the Sender session set if UseSession is
+// enabled, giving the next IDs to its metrics which do not have one yet. The
+// metrics keep their ID so they are recognized by the server when sent
+// again. packet is returned unchanged if it is not an "agent data" packet or
+// its session is not the Sender one.
+//
+// The packets of the session must reach the server in the order of their
+// IDs, so identify lock the session until release is called, once the
+// packet is sent. It wait for the session while ctx allows it, otherwise an
+// OpError with the ctx error is returned.
+func (s *Sender) identify(ctx context.Context, packet *Packet) (p *Packet, release func(), err error) {
+	if packet.Request != "agent data" {
+		return packet, func() {}, nil
+	}
+
+	identified := *packet
+	if identified.Session == "" && s.UseSession {
+		identified.Session = s.Session()
+	}
+	if identified.Session == "" || identified.Session != s.Session() {
+		return packet, func() {}, nil
+	}
+
+	select {
+	case s.sessionSem <- struct{}{}:
+	case <-ctx.Done():
+		return packet, nil, &OpError{Op: OpConnect, Addr: s.Host, Timeout: s.ConnectTimeout, Err: ctx.Err()}
+	}
+	for _, m := range identified.Data {
+		if m.ID == 0 {
+			s.lastID++
+			m.ID = s.lastID
+		}
+	}
+	return &identified, func() { <-s.sessionSem }, nil
+}
+
+// breaksSession report if the values of a packet which failed with err
+// may not have reached the server, so the following packets of the session
+// must not be sent. Spooled packets are replayed without session.
+func breaksSession(err error) bool {
+	var partial *PartialFailureError
+	return err != nil && !errors.As(err, &partial) && !errors.Is(err, ErrSpooled)
+}
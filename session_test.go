@@ -0,0 +1,186 @@
+package zabbix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendSession(t *testing.T) {
+	var packets []Packet
+	addr, errs := fakeZabbix(t, 4, func(header, data []byte) []byte {
+		var packet Packet
+		if err := json.Unmarshal(data, &packet); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		packets = append(packets, packet)
+		return zabbixResponse(`{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"}`)
+	})
+
+	s := NewSender(addr)
+	s.UseSession = true
+
+	metrics := []*Metric{
+		NewMetric("host", "active1", "1", true),
+		NewMetric("host", "trapper", "2", false),
+		NewMetric("host", "active2", "3", true),
+	}
+	// Sending the same metrics again keeps their IDs
+	for i := 0; i < 2; i++ {
+		if _, errActive, _, errTrapper := s.SendMetrics(metrics); errActive != nil || errTrapper != nil {
+			t.Fatalf("error sending metrics: %v, %v", errActive, errTrapper)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	if len(packets) != 4 {
+		t.Fatalf("expected 4 packets, got %d", len(packets))
+	}
+	for i, p := range packets {
+		if p.Request == "sender data" {
+			if p.Session != "" || p.Data[0].ID != 0 {
+				t.Errorf("packet %d: unexpected session %q or id %d in sender data", i, p.Session, p.Data[0].ID)
+			}
+			continue
+		}
+		if len(p.Session) != 32 || p.Session != s.Session() {
+			t.Errorf("packet %d: unexpected session %q, expected %q", i, p.Session, s.Session())
+		}
+		if len(p.Data) != 2 || p.Data[0].ID != 1 || p.Data[1].ID != 2 {
+			t.Errorf("packet %d: unexpected ids %+v", i, p.Data)
+		}
+	}
+
+	if NewSender(addr).Session() == s.Session() {
+		t.Error("expected a different session for another sender")
+	}
+}
+
+func TestSendSessionConcurrent(t *testing.T) {
+	const senders = 50
+
+	var ids []uint64
+	addr, errs := fakeZabbix(t, senders, func(header, data []byte) []byte {
+		var packet Packet
+		if err := json.Unmarshal(data, &packet); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		for _, m := range packet.Data {
+			ids = append(ids, m.ID)
+		}
+		return zabbixResponse(`{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"}`)
+	})
+
+	s := NewSender(addr)
+	s.UseSession = true
+
+	// The server receives the values in the order of their IDs
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.SendMetrics([]*Metric{NewMetric("host", "active1", "1", true), NewMetric("host", "active2", "2", true)})
+		}()
+	}
+	wg.Wait()
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	if len(ids) != 2*senders {
+		t.Fatalf("expected %d values, got %d", 2*senders, len(ids))
+	}
+	for i, id := range ids {
+		if id != uint64(i+1) {
+			t.Fatalf("values received out of order: %v", ids)
+		}
+	}
+}
+
+func TestSendSessionContext(t *testing.T) {
+	// Zabbix server that accepts the connections but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	s := NewSender(listener.Addr().String())
+	s.UseSession = true
+	s.ReadTimeout = 2 * time.Second
+
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		s.Send(NewPacket([]*Metric{NewMetric("host", "active1", "1", true)}, true))
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Waiting for the session respects the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = s.SendContext(ctx, NewPacket([]*Metric{NewMetric("host", "active2", "2", true)}, true))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the send to give up waiting for the session, took %v", elapsed)
+	}
+	var opErr *OpError
+	if !errors.As(err, &opErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected an OpError with the context error, got %v", err)
+	}
+
+	listener.Close()
+	<-blocked
+}
+
+func TestSendSessionStopsOnFailure(t *testing.T) {
+	// The first connection is closed without a response
+	calls := 0
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		calls++
+		return nil
+	})
+
+	s := NewSender(addr)
+	s.UseSession = true
+	s.MaxMetricsPerPacket = 1
+	s.MaxConcurrency = 2
+
+	res := s.SendBatch([]*Metric{
+		NewMetric("host", "active1", "1", true),
+		NewMetric("host", "active2", "2", true),
+	})
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+
+	if len(res.Packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(res.Packets))
+	}
+	var opErr *OpError
+	if !errors.As(res.Packets[0].Err, &opErr) {
+		t.Errorf("expected the first packet to fail, got %v", res.Packets[0].Err)
+	}
+	if !errors.Is(res.Packets[1].Err, ErrNotSent) {
+		t.Errorf("expected the second packet not to be sent, got %v", res.Packets[1].Err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 connection, got %d", calls)
+	}
+}
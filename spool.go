@@ -0,0 +1,427 @@
+package zabbix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSegmentSize = 4 << 20
+	defaultMaxReplay   = 100
+
+	segmentExt = ".seg"
+
+	// recordHeaderSize is the size of the record length and checksum.
+	recordHeaderSize = 8
+)
+
+// ErrSpooled is returned, wrapping the send error, when a packet could not
+// be sent and was saved in the Sender spool to be sent later.
+var ErrSpooled = errors.New("zabbix: packet spooled")
+
+// Spool is a disk backed queue of the packets that could not be sent, so
+// they survive a server outage or a restart. Packets are appended as
+// records to segment files, each record protected by a checksum, and are
+// replayed in order by Replay.
+//
+// Replay is at least once: packets replayed before a crash may be sent
+// again after the spool is reopened.
+type Spool struct {
+	// MaxSize limits the total size of the segments, in bytes. The oldest
+	// segments are removed when it is exceeded. Zero means no limit.
+	MaxSize int64
+
+	// MaxAge is how long a segment is kept after its last write. Zero means
+	// no limit.
+	MaxAge time.Duration
+
+	// SegmentSize is the size after which a new segment is started. Zero
+	// means 4 MiB.
+	SegmentSize int64
+
+	// MaxReplay limits the number of packets sent by a Replay, the next
+	// ones are left to the following calls. Zero means 100, a negative
+	// value means no limit.
+	MaxReplay int
+
+	dir string
+
+	mu       sync.Mutex
+	segments []*segment
+	// active is the last segment, open for appending
+	active *os.File
+	// next is the sequence number of the next segment
+	next uint64
+	// offset of the next record to replay in the first segment
+	offset int64
+	// corrupted counts the corrupted records found
+	corrupted int
+
+	// replayMu serializes the replays
+	replayMu sync.Mutex
+}
+
+// segment is a spool file.
+type segment struct {
+	seq     uint64
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// OpenSpool open the spool stored in dir, creating it if needed. Segments
+// are checked on load: a segment is truncated before its first corrupted
+// or incomplete record, as left by a crash during a write.
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating spool directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading spool directory: %w", err)
+	}
+
+	s := &Spool{dir: dir}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &segment{seq: seq, path: filepath.Join(dir, name)}
+		if err := s.check(seg); err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	if n := len(s.segments); n > 0 {
+		s.next = s.segments[n-1].seq + 1
+	}
+
+	return s, nil
+}
+
+// check verify the records of seg, truncate it at the first invalid one and
+// update its size and modification time.
+func (s *Spool) check(seg *segment) error {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("opening spool segment: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("opening spool segment: %w", err)
+	}
+
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		n, _, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.corrupted++
+			if err := f.Truncate(valid); err != nil {
+				return fmt.Errorf("truncating corrupted spool segment: %w", err)
+			}
+			break
+		}
+		valid += n
+	}
+
+	seg.size = valid
+	seg.modTime = fi.ModTime()
+	return nil
+}
+
+// readRecord read a record and return its size and data. It return io.EOF
+// at the end of the segment, and an error for corrupted or incomplete
+// records.
+func readRecord(r io.Reader) (int64, []byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("incomplete record header: %w", err)
+		}
+		return 0, nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length > defaultMaxFrameSize {
+		return 0, nil, fmt.Errorf("invalid record length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, fmt.Errorf("incomplete record: %w", err)
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:]) {
+		return 0, nil, errors.New("record checksum mismatch")
+	}
+
+	return int64(recordHeaderSize + length), data, nil
+}
+
+// Corrupted return the number of corrupted records found, the records
+// following them in their segment are lost too.
+func (s *Spool) Corrupted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.corrupted
+}
+
+// Size return the size in bytes of the spooled packets.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	return size - s.offset
+}
+
+// Append save packet at the end of the spool. Metrics without clock get the
+// current time, so they keep the time they were sent first. The packet clock
+// is not kept: the server would take the time spent in the spool for a
+// difference between the clocks and shift the values by as much.
+func (s *Spool) Append(packet *Packet) error {
+	p := *packet
+	p.Clock, p.Ns = 0, 0
+	p.Data = make([]*Metric, len(packet.Data))
+	now := time.Now().Unix()
+	for i, m := range packet.Data {
+		metric := *m
+		if metric.Clock == 0 {
+			metric.Clock = now
+		}
+		p.Data[i] = &metric
+	}
+
+	data, err := json.Marshal(&p)
+	if err != nil {
+		return err
+	}
+	// Larger records would be taken for corrupted ones by readRecord
+	if len(data) > defaultMaxFrameSize {
+		return fmt.Errorf("spool record of %d bytes exceeds the limit of %d bytes", len(data), defaultMaxFrameSize)
+	}
+
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record[:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	if err := s.rotate(int64(len(record))); err != nil {
+		return err
+	}
+
+	// A record is written at once and synced, so a crash leaves at most
+	// one incomplete record, removed when the spool is opened again
+	seg := s.segments[len(s.segments)-1]
+	if _, err := s.active.Write(record); err != nil {
+		s.active.Truncate(seg.size)
+		return fmt.Errorf("writing spool segment: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("syncing spool segment: %w", err)
+	}
+	seg.size += int64(len(record))
+	seg.modTime = time.Now()
+
+	s.limit()
+	return nil
+}
+
+// rotate open the active segment, starting a new one if the last segment
+// can not hold length more bytes. s.mu must be held.
+func (s *Spool) rotate(length int64) error {
+	max := s.SegmentSize
+	if max <= 0 {
+		max = defaultSegmentSize
+	}
+
+	if n := len(s.segments); n > 0 && s.segments[n-1].size+length <= max {
+		if s.active != nil {
+			return nil
+		}
+		f, err := os.OpenFile(s.segments[n-1].path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return fmt.Errorf("opening spool segment: %w", err)
+		}
+		s.active = f
+		return nil
+	}
+
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+
+	seg := &segment{seq: s.next, path: filepath.Join(s.dir, fmt.Sprintf("%016x%s", s.next, segmentExt)), modTime: time.Now()}
+
+	f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating spool segment: %w", err)
+	}
+	// Make the new segment entry durable before writing to it
+	if d, err := os.Open(s.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	s.active = f
+	s.segments = append(s.segments, seg)
+	s.next++
+	return nil
+}
+
+// expire remove the segments older than MaxAge. s.mu must be held.
+func (s *Spool) expire() {
+	if s.MaxAge <= 0 {
+		return
+	}
+	for len(s.segments) > 0 && time.Since(s.segments[0].modTime) > s.MaxAge {
+		s.removeFirst()
+	}
+}
+
+// limit remove the oldest segments while the spool exceeds MaxSize, but
+// not the active one. s.mu must be held.
+func (s *Spool) limit() {
+	if s.MaxSize <= 0 {
+		return
+	}
+
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	for len(s.segments) > 1 && size > s.MaxSize {
+		size -= s.segments[0].size
+		s.removeFirst()
+	}
+}
+
+// removeFirst delete the oldest segment. s.mu must be held.
+func (s *Spool) removeFirst() {
+	if len(s.segments) == 1 && s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+	os.Remove(s.segments[0].path)
+	s.segments = s.segments[1:]
+	s.offset = 0
+}
+
+// Replay send the spooled packets in order with send, removing them once
+// sent, until send fails or MaxReplay packets are sent. The error of send is
+// returned and the failed packet is kept to be replayed first next time.
+// Replay return nil at once when another replay is in progress. The spool
+// is not locked while send runs, so packets can be appended meanwhile.
+func (s *Spool) Replay(send func(*Packet) error) error {
+	if !s.replayMu.TryLock() {
+		return nil
+	}
+	defer s.replayMu.Unlock()
+
+	max := s.MaxReplay
+	if max == 0 {
+		max = defaultMaxReplay
+	}
+
+	for sent := 0; max < 0 || sent < max; {
+		s.mu.Lock()
+		seg, offset := s.first()
+		if seg == nil {
+			s.mu.Unlock()
+			return nil
+		}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("opening spool segment: %w", err)
+		}
+		n, data, err := readRecord(io.NewSectionReader(f, offset, seg.size-offset))
+		f.Close()
+		if err != nil {
+			// Skip the rest of a segment corrupted since it was opened
+			s.corrupted++
+			s.removeFirst()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			continue
+		}
+
+		var packet Packet
+		if err := json.Unmarshal(data, &packet); err == nil {
+			if err := send(&packet); err != nil {
+				return err
+			}
+			sent++
+		}
+
+		// The segment may have been removed by expire or limit meanwhile
+		s.mu.Lock()
+		if len(s.segments) > 0 && s.segments[0] == seg && s.offset == offset {
+			s.offset += n
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// first return the first segment and the offset of its next record to
+// replay, removing the expired and fully replayed segments. It return a nil
+// segment when there is nothing to replay. s.mu must be held.
+func (s *Spool) first() (*segment, int64) {
+	s.expire()
+	for len(s.segments) > 0 {
+		if seg := s.segments[0]; s.offset < seg.size {
+			return seg, s.offset
+		}
+		s.removeFirst()
+	}
+	return nil, 0
+}
+
+// Close close the active segment.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
@@ -0,0 +1,286 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func spoolPacket(i int) *Packet {
+	return NewPacket([]*Metric{NewMetric("zabbixTrapper1", "key"+strconv.Itoa(i), strconv.Itoa(i), false)}, false)
+}
+
+// replayValues replay the spool and return the values of the packets.
+func replayValues(t *testing.T, s *Spool) []string {
+	t.Helper()
+
+	var values []string
+	err := s.Replay(func(p *Packet) error {
+		for _, m := range p.Data {
+			values = append(values, m.Value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replaying spool: %v", err)
+	}
+	return values
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SegmentSize = 200
+	for i := 0; i < 5; i++ {
+		if err := s.Append(spoolPacket(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segments) < 2 {
+		t.Errorf("expected several segments, got %d", len(segments))
+	}
+
+	// Packets survive reopening the spool and keep a clock
+	s, err = OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	calls := 0
+	err = s.Replay(func(p *Packet) error {
+		if calls++; calls == 3 {
+			return errors.New("server down")
+		}
+		if p.Request != "sender data" || p.Data[0].Clock == 0 {
+			t.Errorf("unexpected replayed packet %+v", p.Data[0])
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected the replay error to be returned")
+	}
+
+	// The failed packet is replayed first next time
+	if v := replayValues(t, s); len(v) != 3 || v[0] != "2" || v[2] != "4" {
+		t.Errorf("unexpected replayed values %v", v)
+	}
+	if s.Size() != 0 {
+		t.Errorf("expected an empty spool, got %d bytes", s.Size())
+	}
+	if segments, _ = filepath.Glob(filepath.Join(dir, "*.seg")); len(segments) != 0 {
+		t.Errorf("expected replayed segments to be removed, got %v", segments)
+	}
+}
+
+func TestSpoolCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s.Append(spoolPacket(i))
+	}
+	s.Close()
+
+	// Flip a byte of the last record and add an incomplete one
+	path := filepath.Join(dir, "0000000000000000.seg")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	data = append(data, 0x10, 0x00)
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.Corrupted() != 1 {
+		t.Errorf("expected 1 corrupted record, got %d", s.Corrupted())
+	}
+
+	// New records go after the valid ones
+	s.Append(spoolPacket(3))
+	if v := replayValues(t, s); len(v) != 3 || v[0] != "0" || v[1] != "1" || v[2] != "3" {
+		t.Errorf("unexpected replayed values %v", v)
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.SegmentSize = 1
+	s.MaxSize = 400
+	for i := 0; i < 10; i++ {
+		s.Append(spoolPacket(i))
+	}
+	if s.Size() > s.MaxSize {
+		t.Errorf("spool size %d exceeds the limit %d", s.Size(), s.MaxSize)
+	}
+	if v := replayValues(t, s); len(v) == 0 || v[len(v)-1] != "9" || v[0] == "0" {
+		t.Errorf("expected the oldest packets to be dropped, got %v", v)
+	}
+
+	s.MaxAge = 1
+	s.Append(spoolPacket(10))
+	if v := replayValues(t, s); len(v) != 0 {
+		t.Errorf("expected expired packets to be dropped, got %v", v)
+	}
+}
+
+func TestSpoolMaxReplay(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.MaxReplay = 2
+	for i := 0; i < 5; i++ {
+		packet := spoolPacket(i)
+		packet.Clock, packet.Ns = 1700000000, 1
+		s.Append(packet)
+	}
+
+	// Each replay sends at most MaxReplay packets, without their old clock
+	for _, expected := range []string{"0,1", "2,3", "4", ""} {
+		var values []string
+		err := s.Replay(func(p *Packet) error {
+			if p.Clock != 0 || p.Ns != 0 {
+				t.Errorf("expected the packet clock to be cleared, got %d.%d", p.Clock, p.Ns)
+			}
+			values = append(values, p.Data[0].Value)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := strings.Join(values, ","); v != expected {
+			t.Errorf("expected replayed values %q, got %q", expected, v)
+		}
+	}
+}
+
+func TestSpoolAppendDuringReplay(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Append(spoolPacket(0))
+	s.Append(spoolPacket(1))
+
+	// The spool is usable while a packet is sent, appended packets are
+	// replayed by the same replay
+	var values []string
+	err = s.Replay(func(p *Packet) error {
+		values = append(values, p.Data[0].Value)
+		if len(values) == 1 {
+			if err := s.Append(spoolPacket(2)); err != nil {
+				t.Errorf("appending during replay: %v", err)
+			}
+			if s.Size() == 0 {
+				t.Error("expected spooled packets during replay")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[2] != "2" {
+		t.Errorf("unexpected replayed values %v", values)
+	}
+}
+
+func TestSpoolAppendTooLarge(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "key", strings.Repeat("x", defaultMaxFrameSize), false)}, false)
+	if err := s.Append(packet); err == nil {
+		t.Error("expected an error for a record larger than the limit")
+	}
+	if s.Size() != 0 {
+		t.Errorf("expected an empty spool, got %d bytes", s.Size())
+	}
+}
+
+func TestSendSpool(t *testing.T) {
+	// Get a free port with nothing listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	spool, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	s := NewSender(addr)
+	s.Spool = spool
+
+	if _, err = s.Send(spoolPacket(0)); !errors.Is(err, ErrSpooled) {
+		t.Fatalf("expected spooled error, got %v", err)
+	}
+	var opErr *OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("expected the send error to be wrapped, got %v", err)
+	}
+
+	// Once the server is back the spooled packet follows the new one
+	var values []string
+	addr, errs := fakeZabbixOn(t, addr, 2, func(header, data []byte) []byte {
+		var request ZabbixRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
+		values = append(values, request.Data[0].Value)
+		return zabbixResponse(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+	})
+
+	if _, err = s.Send(spoolPacket(1)); err != nil {
+		t.Fatalf("error sending packet: %v", err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
+	if len(values) != 2 || values[0] != "1" || values[1] != "0" {
+		t.Errorf("unexpected values received %v", values)
+	}
+	if spool.Size() != 0 {
+		t.Errorf("expected an empty spool, got %d bytes", spool.Size())
+	}
+}
@@ -3,14 +3,13 @@
 package zabbix
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,9 +25,70 @@ type Metric struct {
 	Key    string `json:"key"`
 	Value  string `json:"value"`
 	Clock  int64  `json:"clock,omitempty"`
+	Ns     int    `json:"ns,omitempty"`
 	Active bool   `json:"-"`
+
+	// ID is the value identifier within the packet session, see
+	// Sender.UseSession.
+	ID uint64 `json:"id,omitempty"`
+
+	// State is StateNotSupported for the active items which could not be
+	// collected, Value then holds the reason.
+	State int `json:"state,omitempty"`
+
+	// LastLogSize and MTime are the position in the file of the log items
+	// values, sent back by the server with the active checks.
+	LastLogSize int64 `json:"lastlogsize,omitempty"`
+	MTime       int64 `json:"mtime,omitempty"`
+
+	// Timestamp, Source, Severity and EventID describe the values of the
+	// log and eventlog items.
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Severity  int    `json:"severity,omitempty"`
+	EventID   int64  `json:"eventid,omitempty"`
+
+	// exactNs makes Ns sent even when zero, as zabbix_sender -N does. The
+	// server picks the ns of the values with a clock but without ns.
+	exactNs bool
+}
+
+// MarshalJSON encode m, with its ns when it was set by NewMetricTime, by
+// StampMetrics or decoded.
+func (m Metric) MarshalJSON() ([]byte, error) {
+	type metric Metric
+	if !m.exactNs {
+		return json.Marshal(metric(m))
+	}
+	return json.Marshal(struct {
+		metric
+		Ns int `json:"ns"`
+	}{metric(m), m.Ns})
+}
+
+// UnmarshalJSON decode m, remembering if it had a ns.
+func (m *Metric) UnmarshalJSON(data []byte) error {
+	type metric Metric
+	var v struct {
+		metric
+		Ns *int `json:"ns"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = Metric(v.metric)
+	if v.Ns != nil {
+		m.Ns, m.exactNs = *v.Ns, true
+	}
+	return nil
 }
 
+// States of a Metric.
+const (
+	StateNormal       = 0
+	StateNotSupported = 1
+)
+
 // NewMetric return a zabbix Metric with the values specified
 // agentActive should be set to true if we are sending to a Zabbix Agent (active) item
 func NewMetric(host, key, value string, agentActive bool, clock ...int64) *Metric {
@@ -39,13 +99,37 @@ func NewMetric(host, key, value string, agentActive bool, clock ...int64) *Metri
 	return m
 }
 
+// NewMetricTime return a zabbix Metric with its clock and ns set from t. The
+// ns is sent even when zero, as zabbix_sender -N does.
+func NewMetricTime(host, key, value string, agentActive bool, t time.Time) *Metric {
+	return &Metric{Host: host, Key: key, Value: value, Clock: t.Unix(), Ns: t.Nanosecond(), Active: agentActive, exactNs: true}
+}
+
 // Packet class.
 type Packet struct {
 	Request      string    `json:"request"`
 	Data         []*Metric `json:"data,omitempty"`
 	Clock        int64     `json:"clock,omitempty"`
+	Ns           int       `json:"ns,omitempty"`
 	Host         string    `json:"host,omitempty"`
 	HostMetadata string    `json:"host_metadata,omitempty"`
+
+	// Session identifies the sender of "agent data" packets, the server
+	// drops the values whose ID it already received in the session.
+	Session string `json:"session,omitempty"`
+}
+
+// MarshalJSON encode p, with its ns whenever it has a clock, as
+// zabbix_sender does.
+func (p Packet) MarshalJSON() ([]byte, error) {
+	type packet Packet
+	if p.Clock == 0 {
+		return json.Marshal(packet(p))
+	}
+	return json.Marshal(struct {
+		packet
+		Ns int `json:"ns"`
+	}{packet(p), p.Ns})
 }
 
 // Reponse is a response for autoregister method
@@ -65,17 +149,17 @@ func (r *Response) GetInfo() (*ResponseInfo, error) {
 	ret := new(ResponseInfo)
 
 	if r.Response != "success" {
-		return nil, fmt.Errorf("Can not process info if response not Success (%s)", r.Response)
+		return nil, &ResponseError{Response: *r}
 	}
 
 	sp := strings.Split(r.Info, ";")
 	if len(sp) != 4 {
-		return nil, fmt.Errorf("Error in splited data, expected 4 got %d for data (%s)", len(sp), r.Info)
+		return nil, fmt.Errorf("%w: expected 4 fields in info, got %d (%s)", ErrInvalidResponse, len(sp), r.Info)
 	}
 	for i := range sp {
 		sp2 := strings.Split(sp[i], ":")
 		if len(sp2) != 2 {
-			return nil, fmt.Errorf("Error in splited data, expected 2 got %d for data (%s)", len(sp2), sp[i])
+			return nil, fmt.Errorf("%w: expected key and value in info, got %d fields (%s)", ErrInvalidResponse, len(sp2), sp[i])
 		}
 		key := strings.TrimSpace(sp2[0])
 		value := strings.TrimSpace(sp2[1])
@@ -89,10 +173,12 @@ func (r *Response) GetInfo() (*ResponseInfo, error) {
 			ret.Total, err = strconv.Atoi(value)
 		case "seconds spent":
 			var f float64
-			if f, err = strconv.ParseFloat(value, 64); err != nil {
-				return nil, fmt.Errorf("Error in parsing seconds spent value [%s] error: %s", value, err)
+			if f, err = strconv.ParseFloat(value, 64); err == nil {
+				ret.Spent = time.Duration(int64(f * 1000000000.0))
 			}
-			ret.Spent = time.Duration(int64(f * 1000000000.0))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: parsing %s value [%s]: %w", ErrInvalidResponse, key, value, err)
 		}
 
 	}
@@ -100,6 +186,20 @@ func (r *Response) GetInfo() (*ResponseInfo, error) {
 	return ret, nil
 }
 
+// String return the info formatted as in the server response.
+func (i *ResponseInfo) String() string {
+	return fmt.Sprintf("processed: %d; failed: %d; total: %d; seconds spent: %.6f",
+		i.Processed, i.Failed, i.Total, i.Spent.Seconds())
+}
+
+// add the counters of other to i.
+func (i *ResponseInfo) add(other *ResponseInfo) {
+	i.Processed += other.Processed
+	i.Failed += other.Failed
+	i.Total += other.Total
+	i.Spent += other.Spent
+}
+
 // NewPacket return a zabbix packet with a list of metrics
 func NewPacket(data []*Metric, agentActive bool, clock ...int64) *Packet {
 	var request string
@@ -116,20 +216,126 @@ func NewPacket(data []*Metric, agentActive bool, clock ...int64) *Packet {
 	return p
 }
 
-// DataLen Packet class method, return 8 bytes with packet length in little endian order
+// NewPacketTime return a zabbix packet with a list of metrics and its clock
+// and ns set from t.
+func NewPacketTime(data []*Metric, agentActive bool, t time.Time) *Packet {
+	p := NewPacket(data, agentActive, t.Unix())
+	p.Ns = t.Nanosecond()
+	return p
+}
+
+// DataLen return the 8 bytes data length field of the standard header of
+// the packet, in little endian order. It return nil when the packet can not
+// be marshaled or does not fit in a standard header.
+//
+// Deprecated: use Encoder.EncodeJSON, which writes the header with the
+// data and supports large packets.
 func (p *Packet) DataLen() []byte {
-	dataLen := make([]byte, 8)
-	JSONData, _ := json.Marshal(p)
-	binary.LittleEndian.PutUint32(dataLen, uint32(len(JSONData)))
-	return dataLen
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil
+	}
+	header, err := Header{Flags: FlagZabbixProtocol, DataLength: uint64(len(data))}.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return header[5:]
 }
 
 // Sender class
 type Sender struct {
-	Host           string
+	Host string
+
+	// Timeouts of each step of a send, zero means no timeout. The deadline
+	// of the context given to the *Context methods also applies.
 	ConnectTimeout time.Duration
 	ReadTimeout    time.Duration
 	WriteTimeout   time.Duration
+
+	// Compress enables zlib compression of the sent packets (flag 0x03).
+	// Supported by Zabbix 4.0 and newer.
+	Compress bool
+
+	// LargePackets allows sending packets bigger than 4 GiB using the large
+	// packet flag (0x04) and 64 bits lengths. Only enable it if the server
+	// supports large packets, otherwise such packets fail with a
+	// PacketTooLargeError before being sent.
+	LargePackets bool
+
+	// MaxResponseSize limits the size of the response accepted from the
+	// server, uncompressed. Zero means 16 MiB.
+	MaxResponseSize uint64
+
+	// Strict makes sending metrics fail when the server does not process all
+	// of them: the response info is parsed and a PartialFailureError is
+	// returned when it reports failed values. It also fails when the info
+	// can not be parsed.
+	Strict bool
+
+	// Bisect enables a diagnostic mode to find which metrics the server
+	// rejected, for example because their item does not exist. On partial
+	// failure the metrics are split and the halves are sent again until the
+	// failing ones are isolated, they are returned in the Rejected field of
+	// the PartialFailureError. It implies Strict.
+	//
+	// Resent values which the server accepts are stored again, so only use
+	// it for testing or troubleshooting.
+	Bisect bool
+
+	// MaxMetricsPerPacket and MaxPacketSize, in bytes of the JSON data,
+	// limit the size of the packets sent by SendMetrics and SendBatch,
+	// zero means no limit. Bigger batches are split in several packets, up
+	// to MaxConcurrency of them are sent at the same time.
+	MaxMetricsPerPacket int
+	MaxPacketSize       int
+	MaxConcurrency      int
+
+	// Spool, if set, saves the packets of metrics which could not be sent
+	// because of a network error, and the error returned matches
+	// ErrSpooled. They are replayed after the next successful sends, by
+	// the Send calls themselves before they return, up to Spool.MaxReplay
+	// packets at a time.
+	Spool *Spool
+
+	// Retry, if set, makes the Sender send a packet again when it fails
+	// with a retryable error.
+	Retry *RetryPolicy
+
+	// OnAttempt, if set, is called after every attempt to send a packet,
+	// retried or not.
+	OnAttempt func(Attempt)
+
+	// UseSession makes the Sender set its session token on "agent data"
+	// packets and give their metrics increasing IDs, as Zabbix agents do.
+	// The server then ignores the values it already received, so sending
+	// the same metrics again, for example after a timeout, does not store
+	// them twice. Supported by Zabbix 4.4 and newer.
+	//
+	// The packets of the session are sent one at a time, concurrent sends
+	// wait for each other until their context is done. The IDs are set on
+	// the given metrics, so a Metric sent again with a new value would be
+	// ignored as already received: create a new Metric for every value.
+	UseSession bool
+
+	// StampPackets sets the clock and ns of the packets of metrics to the
+	// time they are sent, at every attempt. The server uses them to correct
+	// the time of the values when the clocks of the sender and the server
+	// differ.
+	StampPackets bool
+
+	// StampMetrics sets the clock and ns of the metrics without clock to
+	// the time they are first sent, so the server does not use the time it
+	// receives them, which differs for retried and spooled values. The
+	// metrics are copied, those given are not modified.
+	StampMetrics bool
+
+	sessionOnce sync.Once
+	session     string
+
+	// sessionSem is a 1-slot semaphore held while the packets of the
+	// session are sent, it protects lastID
+	sessionSem chan struct{}
+	lastID     uint64
 }
 
 // NewSender return a sender object to send metrics using default values for timeouts
@@ -157,30 +363,8 @@ func NewSenderTimeout(
 	}
 }
 
-// getHeader return zabbix header.
-// https://www.zabbix.com/documentation/4.0/manual/appendix/protocols/header_datalen
-func (s *Sender) getHeader() []byte {
-	return []byte("ZBXD\x01")
-}
-
-// read data from connection.
-func (s *Sender) read(conn net.Conn) ([]byte, error) {
-	res, err := ioutil.ReadAll(conn)
-	if err != nil {
-		return res, fmt.Errorf("receiving data: %s", err.Error())
-	}
-
-	return res, nil
-}
-
-// SendMetrics send an array of metrics, making different packets for
-// trapper and active items.
-// The response for trapper metrics is in the first element of the res array and err array
-// Response for active metrics is in the second element of the res array and error array
-func (s *Sender) SendMetrics(metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
-	var trapperMetrics []*Metric
-	var activeMetrics []*Metric
-
+// splitMetrics return the trapper and the active metrics.
+func splitMetrics(metrics []*Metric) (trapperMetrics, activeMetrics []*Metric) {
 	for i := range metrics {
 		if metrics[i].Active {
 			activeMetrics = append(activeMetrics, metrics[i])
@@ -188,16 +372,31 @@ func (s *Sender) SendMetrics(metrics []*Metric) (resActive Response, errActive e
 			trapperMetrics = append(trapperMetrics, metrics[i])
 		}
 	}
+	return trapperMetrics, activeMetrics
+}
+
+// SendMetrics send an array of metrics, making different packets for
+// trapper and active items, split according to the Sender packet limits.
+// The response and error for active metrics are returned first, followed by
+// the response and error for trapper metrics. See SendBatch for a single
+// result value.
+func (s *Sender) SendMetrics(metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	return s.SendMetricsContext(context.Background(), metrics)
+}
+
+// SendMetricsContext is like SendMetrics but ctx can cancel the sending.
+func (s *Sender) SendMetricsContext(ctx context.Context, metrics []*Metric) (resActive Response, errActive error, resTrapper Response, errTrapper error) {
+	trapperMetrics, activeMetrics := splitMetrics(metrics)
 
 	if len(trapperMetrics) > 0 {
 
 		packetTrapper := NewPacket(trapperMetrics, false)
-		resTrapper, errTrapper = s.Send(packetTrapper)
+		resTrapper, errTrapper = mergeResponses(s.sendChunks(ctx, packetTrapper))
 	}
 
 	if len(activeMetrics) > 0 {
 		packetActive := NewPacket(activeMetrics, true)
-		resActive, errActive = s.Send(packetActive)
+		resActive, errActive = mergeResponses(s.sendChunks(ctx, packetActive))
 	}
 
 	return resActive, errActive, resTrapper, errTrapper
@@ -205,59 +404,249 @@ func (s *Sender) SendMetrics(metrics []*Metric) (resActive Response, errActive e
 
 // Send connects to Zabbix, send the data, return the response and close the connection
 func (s *Sender) Send(packet *Packet) (res Response, err error) {
+	return s.SendContext(context.Background(), packet)
+}
+
+// SendContext is like Send but ctx can cancel the connection, the sending of
+// the data and the reading of the response. Its deadline applies in addition
+// to the Sender timeouts.
+func (s *Sender) SendContext(ctx context.Context, packet *Packet) (res Response, err error) {
+	packet, release, err := s.identify(ctx, packet)
+	if err != nil {
+		return res, err
+	}
+	res, err = s.sendPacket(ctx, packet)
+	release()
+
+	if err == nil && len(packet.Data) > 0 {
+		s.replaySpool(ctx)
+	}
+	return res, err
+}
+
+// sendPacket send an identified packet, spooling it on network errors.
+func (s *Sender) sendPacket(ctx context.Context, packet *Packet) (res Response, err error) {
+	packet = s.stampMetrics(packet)
+	res, err = s.sendChecked(ctx, packet)
+	if s.Spool == nil || len(packet.Data) == 0 {
+		return res, err
+	}
+
+	var opErr *OpError
+	var tooLarge *PacketTooLargeError
+	if errors.As(err, &opErr) && !errors.As(err, &tooLarge) {
+		// Replayed packets come after newer ones, the server would ignore
+		// them in the session
+		spooled := *packet
+		spooled.Session = ""
+		if spoolErr := s.Spool.Append(&spooled); spoolErr != nil {
+			return res, fmt.Errorf("%w (spooling failed: %v)", err, spoolErr)
+		}
+		return res, fmt.Errorf("%w: %w", ErrSpooled, err)
+	}
+
+	return res, err
+}
+
+// replaySpool send the spooled packets after a successful send: the server
+// is back, send what it missed. At most Spool.MaxReplay packets are sent, so
+// the caller is not held until the whole spool is sent.
+func (s *Sender) replaySpool(ctx context.Context) {
+	if s.Spool == nil {
+		return
+	}
+	s.Spool.Replay(func(p *Packet) error {
+		_, err := s.sendChecked(ctx, p)
+		var opErr *OpError
+		if errors.As(err, &opErr) {
+			return err
+		}
+		return nil
+	})
+}
+
+// stampMetrics return packet with the metrics without clock replaced by
+// copies stamped with the current time, if StampMetrics is enabled.
+func (s *Sender) stampMetrics(packet *Packet) *Packet {
+	if !s.StampMetrics {
+		return packet
+	}
+
+	now := time.Now()
+	p := *packet
+	p.Data = make([]*Metric, len(packet.Data))
+	for i, m := range packet.Data {
+		if m.Clock == 0 {
+			metric := *m
+			metric.Clock, metric.Ns, metric.exactNs = now.Unix(), now.Nanosecond(), true
+			m = &metric
+		}
+		p.Data[i] = m
+	}
+	return &p
+}
+
+// sendChecked send the packet, retrying according to the Retry policy, and
+// check the response info according to the Strict and Bisect modes.
+func (s *Sender) sendChecked(ctx context.Context, packet *Packet) (res Response, err error) {
+	res, err = s.retry(ctx, packet, s.sendStrict)
+
+	var partial *PartialFailureError
+	if s.Bisect && errors.As(err, &partial) {
+		var bisectErr error
+		if partial.Rejected, bisectErr = s.bisect(ctx, packet, packet.Data, partial.Info.Failed); bisectErr != nil {
+			return res, fmt.Errorf("%w (bisection failed: %v)", partial, bisectErr)
+		}
+	}
+
+	return res, err
+}
+
+// sendStrict send the packet and, in Strict and Bisect modes, return a
+// PartialFailureError when the server failed some of its values.
+func (s *Sender) sendStrict(ctx context.Context, packet *Packet) (res Response, err error) {
+	res, err = s.send(ctx, packet)
+	if err != nil {
+		return res, err
+	}
+
+	// Only packets carrying metrics get a processed/failed info
+	if (s.Strict || s.Bisect) && len(packet.Data) > 0 {
+		info, err := res.GetInfo()
+		if err != nil {
+			return res, err
+		}
+		if info.Failed > 0 {
+			return res, &PartialFailureError{Info: info, Metrics: packet.Data}
+		}
+	}
+
+	return res, nil
+}
+
+// send the packet and return the server response.
+func (s *Sender) send(ctx context.Context, packet *Packet) (res Response, err error) {
+	data, err := s.sendJSON(ctx, packet)
+	if err != nil {
+		return res, err
+	}
+
+	if err := json.Unmarshal(data, &res); err != nil {
+		return res, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return res, nil
+}
+
+// sendJSON send the packet and return the data of the server response.
+func (s *Sender) sendJSON(ctx context.Context, packet *Packet) ([]byte, error) {
+	if s.StampPackets && len(packet.Data) > 0 {
+		p := *packet
+		now := time.Now()
+		p.Clock, p.Ns = now.Unix(), now.Nanosecond()
+		packet = &p
+	}
+
+	return s.roundTrip(ctx, func(enc *Encoder) error { return enc.EncodeJSON(packet) })
+}
+
+// roundTrip send the frame written with encode and return the data of the
+// response frame.
+func (s *Sender) roundTrip(ctx context.Context, encode func(*Encoder) error) ([]byte, error) {
 	// Timeout to resolve and connect to the server
-	conn, err := net.DialTimeout("tcp", s.Host, s.ConnectTimeout)
+	conn, err := dial(ctx, s.Host, s.ConnectTimeout)
 	if err != nil {
-		return res, fmt.Errorf("connecting to server (timeout=%v): %v", s.ConnectTimeout, err)
+		return nil, &OpError{Op: OpConnect, Addr: s.Host, Timeout: s.ConnectTimeout, Err: contextError(ctx, err)}
 	}
 	defer conn.Close()
 
-	dataPacket, _ := json.Marshal(packet)
-
-	// Fill buffer
-	buffer := append(s.getHeader(), packet.DataLen()...)
-	buffer = append(buffer, dataPacket...)
+	stop := watchContext(ctx, conn)
+	defer stop()
 
 	// Write timeout
-	conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	conn.SetWriteDeadline(deadline(ctx, s.WriteTimeout))
+	if ctx.Err() != nil {
+		return nil, &OpError{Op: OpWrite, Addr: s.Host, Timeout: s.WriteTimeout, Err: ctx.Err()}
+	}
 
 	// Send packet to zabbix
-	_, err = conn.Write(buffer)
+	enc := NewEncoder(conn)
+	enc.Compress = s.Compress
+	enc.LargePackets = s.LargePackets
+	err = encode(enc)
 	if err != nil {
-		return res, fmt.Errorf("sending the data (timeout=%v): %s", s.WriteTimeout, err.Error())
+		return nil, &OpError{Op: OpWrite, Addr: s.Host, Timeout: s.WriteTimeout, Err: contextError(ctx, err)}
 	}
 
 	// Read timeout
-	conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	conn.SetReadDeadline(deadline(ctx, s.ReadTimeout))
+	if ctx.Err() != nil {
+		return nil, &OpError{Op: OpRead, Addr: s.Host, Timeout: s.ReadTimeout, Err: ctx.Err()}
+	}
 
 	// Read response from server
-	response, err := s.read(conn)
+	dec := NewDecoder(conn)
+	dec.MaxSize = s.MaxResponseSize
+	_, data, err := dec.Decode()
 	if err != nil {
-		return res, fmt.Errorf("reading the response (timeout=%v): %s", s.ReadTimeout, err)
+		return nil, &OpError{Op: OpRead, Addr: s.Host, Timeout: s.ReadTimeout, Err: contextError(ctx, err)}
 	}
 
-	header := response[:5]
-	data := response[13:]
+	return data, nil
+}
 
-	if !bytes.Equal(header, s.getHeader()) {
-		return res, fmt.Errorf("got no valid header [%+v] , expected [%+v]", header, s.getHeader())
+// bisect return the metrics rejected by the server, knowing that it failed
+// to process failed of them. The metrics are split in halves and the first
+// half is sent again in a copy of packet, so it keeps the packet clock. The
+// failures of the second half are deduced from the first half ones.
+func (s *Sender) bisect(ctx context.Context, packet *Packet, metrics []*Metric, failed int) ([]*Metric, error) {
+	if failed <= 0 {
+		return nil, nil
+	}
+	if failed >= len(metrics) {
+		return metrics, nil
 	}
 
-	if err := json.Unmarshal(data, &res); err != nil {
-		return res, fmt.Errorf("zabbix response is not valid: %v", err)
+	half := len(metrics) / 2
+	sub := *packet
+	sub.Data = metrics[:half]
+	// Resent values would be dropped as duplicates in the session
+	sub.Session = ""
+
+	res, err := s.retry(ctx, &sub, s.send)
+	if err != nil {
+		return nil, err
+	}
+	info, err := res.GetInfo()
+	if err != nil {
+		return nil, err
 	}
 
-	return res, nil
+	left, err := s.bisect(ctx, packet, metrics[:half], info.Failed)
+	if err != nil {
+		return nil, err
+	}
+	right, err := s.bisect(ctx, packet, metrics[half:], failed-info.Failed)
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
 }
 
 // RegisterHost provides a register a Zabbix's host with Autoregister method.
 func (s *Sender) RegisterHost(host, hostmetadata string) error {
+	return s.RegisterHostContext(context.Background(), host, hostmetadata)
+}
+
+// RegisterHostContext is like RegisterHost but ctx can cancel the
+// registration.
+func (s *Sender) RegisterHostContext(ctx context.Context, host, hostmetadata string) error {
 
 	p := &Packet{Request: "active checks", Host: host, HostMetadata: hostmetadata}
 
-	res, err := s.Send(p)
+	res, err := s.SendContext(ctx, p)
 	if err != nil {
-		return fmt.Errorf("sending packet: %v", err)
+		return fmt.Errorf("sending packet: %w", err)
 	}
 
 	if res.Response == "success" {
@@ -268,13 +657,13 @@ func (s *Sender) RegisterHost(host, hostmetadata string) error {
 	// We retry the process to get success response to verify the host registration properly
 	p = &Packet{Request: "active checks", Host: host, HostMetadata: hostmetadata}
 
-	res, err = s.Send(p)
+	res, err = s.SendContext(ctx, p)
 	if err != nil {
-		return fmt.Errorf("sending packet: %v", err)
+		return fmt.Errorf("sending packet: %w", err)
 	}
 
 	if res.Response == "failed" {
-		return fmt.Errorf("autoregistration failed, verify hostmetadata")
+		return fmt.Errorf("autoregistration failed, verify hostmetadata: %w", &ResponseError{Response: res})
 	}
 
 	return nil
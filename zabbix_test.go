@@ -1,11 +1,19 @@
 package zabbix
 
 import (
+	"bytes"
+	"compress/zlib"
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"strings"
 	"testing"
+	"time"
 )
 
 type ZabbixRequestData struct {
@@ -23,593 +31,394 @@ type ZabbixRequest struct {
 	HostMetadata string              `json:"host_metadata"`
 }
 
-func TestSendActiveMetric(t *testing.T) {
-	zabbixHost := "127.0.0.1:10051"
+// fakeZabbix simulates a Zabbix server on an ephemeral port answering n
+// requests with reply, which receives the raw header and data of each
+// request. The returned channel gets nil once all requests were served or
+// the first error found.
+func fakeZabbix(t *testing.T, n int, reply func(header, data []byte) []byte) (string, <-chan error) {
+	t.Helper()
+	return fakeZabbixOn(t, "127.0.0.1:0", n, reply)
+}
+
+// fakeZabbixOn is like fakeZabbix but listens on addr.
+func fakeZabbixOn(t *testing.T, addr string, n int, reply func(header, data []byte) []byte) (string, <-chan error) {
+	t.Helper()
 
-	// Simulate a Zabbix server to get the data sent
-	listener, lerr := net.Listen("tcp", zabbixHost)
-	if lerr != nil {
-		t.Fatal(lerr)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
 	}
-	defer listener.Close()
+	t.Cleanup(func() { listener.Close() })
 
 	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
 
-	go func(chan error) {
-		conn, err := listener.Accept()
-		if err != nil {
-			errs <- err
-		}
-
-		// Obtain request from the mock zabbix server
-		// Read protocol header and version
-		header := make([]byte, 5)
-		_, err = conn.Read(header)
-		if err != nil {
-			errs <- err
-		}
-
-		// Read data length
-		dataLengthRaw := make([]byte, 8)
-		_, err = conn.Read(dataLengthRaw)
-		if err != nil {
-			errs <- err
-		}
-
-		dataLength := binary.LittleEndian.Uint64(dataLengthRaw)
-
-		// Read data content
-		content := make([]byte, dataLength)
-		_, err = conn.Read(content)
-		if err != nil {
-			errs <- err
-		}
-
-		// The zabbix output checks that there are not errors
-		// Zabbix header length not used, set to 1
-		resp := []byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"info\":\"processed: 1; failed: 0; total: 1; seconds spent: 0.000030\"}")
-		_, err = conn.Write(resp)
-		if err != nil {
-			errs <- err
-		}
-
-		// Close connection after reading the client data
-		conn.Close()
+			// Read protocol header, version and data length
+			header := make([]byte, 13)
+			if _, err = io.ReadFull(conn, header); err != nil {
+				conn.Close()
+				errs <- err
+				return
+			}
 
-		// Strip zabbix header and get JSON request
-		var request ZabbixRequest
-		err = json.Unmarshal(content, &request)
-		if err != nil {
-			errs <- err
-		}
+			// Read data content
+			data := make([]byte, binary.LittleEndian.Uint32(header[5:9]))
+			if _, err = io.ReadFull(conn, data); err != nil {
+				conn.Close()
+				errs <- err
+				return
+			}
 
-		expectedRequest := "agent data"
-		if expectedRequest != request.Request {
-			errs <- fmt.Errorf("Incorrect request field received, expected '%s'", expectedRequest)
+			_, err = conn.Write(reply(header, data))
+			conn.Close()
+			if err != nil {
+				errs <- err
+				return
+			}
 		}
-
-		// End zabbix fake backend
 		errs <- nil
-	}(errs)
+	}()
 
-	m := NewMetric("zabbixAgent1", "ping", "13", true)
+	return listener.Addr().String(), errs
+}
 
-	s := NewSender(zabbixHost)
-	resActive, errActive, resTrapper, errTrapper := s.SendMetrics([]*Metric{m})
-	if errActive != nil {
-		t.Fatalf("error sending active metric: %v", errActive)
-	}
-	if errTrapper != nil {
-		t.Fatalf("trapper error should be nil, we are not sending trapper metrics: %v", errTrapper)
-	}
+// zabbixResponse return body framed as a Zabbix response.
+func zabbixResponse(body string) []byte {
+	return frame(Header{Flags: FlagZabbixProtocol, DataLength: uint64(len(body))}, []byte(body))
+}
 
-	raInfo, err := resActive.GetInfo()
+// frame return data prefixed with the header h.
+func frame(h Header, data []byte) []byte {
+	header, err := h.MarshalBinary()
 	if err != nil {
-		t.Fatalf("error in response Trapper: %v", err)
+		panic(err)
 	}
+	return append(header, data...)
+}
 
-	if raInfo.Failed != 0 {
-		t.Errorf("Failed error expected 0 got %d", raInfo.Failed)
-	}
-	if raInfo.Processed != 1 {
-		t.Errorf("Processed error expected 1 got %d", raInfo.Processed)
-	}
-	if raInfo.Total != 1 {
-		t.Errorf("Total error expected 1 got %d", raInfo.Total)
-	}
+// zlibCompress return data compressed with zlib.
+func zlibCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
 
-	_, err = resTrapper.GetInfo()
-	if err == nil {
-		t.Fatalf("No response trapper expected: %v", err)
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
 	}
-
-	// Wait for zabbix server emulator to finish
-	err = <-errs
-	if err != nil {
-		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
 	}
+	return buf.Bytes()
 }
 
-func TestSendTrapperMetric(t *testing.T) {
-	zabbixHost := "127.0.0.1:10051"
-
-	// Simulate a Zabbix server to get the data sent
-	listener, lerr := net.Listen("tcp", zabbixHost)
-	if lerr != nil {
-		t.Fatal(lerr)
-	}
-	defer listener.Close()
-
-	errs := make(chan error, 1)
+func TestSendCompressed(t *testing.T) {
+	body := []byte(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
 
-	go func(chan error) {
-		conn, err := listener.Accept()
-		if err != nil {
-			errs <- err
+	var request ZabbixRequest
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		if header[4] != 0x03 {
+			t.Errorf("expected compressed flag 0x03, got 0x%02x", header[4])
 		}
 
-		// Obtain request from the mock zabbix server
-		// Read protocol header and version
-		header := make([]byte, 5)
-		_, err = conn.Read(header)
+		zr, err := zlib.NewReader(bytes.NewReader(data))
 		if err != nil {
-			errs <- err
+			t.Errorf("request is not zlib compressed: %v", err)
+			return nil
 		}
-
-		// Read data length
-		dataLengthRaw := make([]byte, 8)
-		_, err = conn.Read(dataLengthRaw)
+		content, err := ioutil.ReadAll(zr)
 		if err != nil {
-			errs <- err
+			t.Errorf("decompressing request: %v", err)
 		}
-
-		dataLength := binary.LittleEndian.Uint64(dataLengthRaw)
-
-		// Read data content
-		content := make([]byte, dataLength)
-		_, err = conn.Read(content)
-		if err != nil {
-			errs <- err
+		if l := binary.LittleEndian.Uint32(header[9:13]); int(l) != len(content) {
+			t.Errorf("expected uncompressed length %d, got %d", len(content), l)
 		}
-
-		// The zabbix output checks that there are not errors
-		resp := []byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"info\":\"processed: 1; failed: 0; total: 1; seconds spent: 0.000030\"}")
-		_, err = conn.Write(resp)
-		if err != nil {
-			errs <- err
+		if err = json.Unmarshal(content, &request); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
 		}
 
-		// Close connection after reading the client data
-		conn.Close()
+		// Reply compressed as the Zabbix server does
+		compressed := zlibCompress(t, body)
+		return frame(Header{Flags: 0x03, DataLength: uint64(len(compressed)), Reserved: uint64(len(body))}, compressed)
+	})
 
-		// Strip zabbix header and get JSON request
-		var request ZabbixRequest
-		err = json.Unmarshal(content, &request)
-		if err != nil {
-			errs <- err
-		}
-
-		expectedRequest := "sender data"
-		if expectedRequest != request.Request {
-			errs <- fmt.Errorf("Incorrect request field received, expected '%s'", expectedRequest)
-		}
-
-		// End zabbix fake backend
-		errs <- nil
-	}(errs)
-
-	m := NewMetric("zabbixAgent1", "ping", "13", false)
-
-	s := NewSender(zabbixHost)
-	resActive, errActive, resTrapper, errTrapper := s.SendMetrics([]*Metric{m})
-	if errTrapper != nil {
-		t.Fatalf("error sending trapper metric: %v", errTrapper)
+	s := NewSender(addr)
+	s.Compress = true
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
+	if err != nil {
+		t.Fatalf("error sending compressed packet: %v", err)
 	}
-	if errActive != nil {
-		t.Fatalf("active error should be nil, we are not sending zabbix agent metrics: %v", errActive)
+	if res.Response != "success" {
+		t.Errorf("expected success response, got %q", res.Response)
 	}
 
-	rtInfo, err := resTrapper.GetInfo()
-	if err != nil {
-		t.Fatalf("error in response Trapper: %v", err)
+	if err = <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
 	}
 
-	if rtInfo.Failed != 0 {
-		t.Errorf("Failed error expected 0 got %d", rtInfo.Failed)
-	}
-	if rtInfo.Processed != 1 {
-		t.Errorf("Processed error expected 1 got %d", rtInfo.Processed)
-	}
-	if rtInfo.Total != 1 {
-		t.Errorf("Total error expected 1 got %d", rtInfo.Total)
+	if request.Request != "sender data" || len(request.Data) != 1 || request.Data[0].Key != "ping" {
+		t.Errorf("unexpected request received: %+v", request)
 	}
+}
 
-	_, err = resActive.GetInfo()
-	if err == nil {
-		t.Fatalf("No response active expected: %v", err)
-	}
+func TestSendLargePacketResponse(t *testing.T) {
+	body := []byte(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000030"}`)
+
+	addr, errs := fakeZabbix(t, 1, func(header, data []byte) []byte {
+		return frame(Header{Flags: 0x05, DataLength: uint64(len(body))}, body)
+	})
 
-	// Wait for zabbix server emulator to finish
-	err = <-errs
+	s := NewSender(addr)
+	s.LargePackets = true
+	res, err := s.Send(NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false))
 	if err != nil {
+		t.Fatalf("error reading large packet response: %v", err)
+	}
+	if res.Response != "success" {
+		t.Errorf("expected success response, got %q", res.Response)
+	}
+
+	if err = <-errs; err != nil {
 		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
 	}
 }
 
-func TestSendActiveAndTrapperMetric(t *testing.T) {
-	zabbixHost := "127.0.0.1:10051"
-
-	// Simulate a Zabbix server to get the data sent
-	listener, lerr := net.Listen("tcp", zabbixHost)
-	if lerr != nil {
-		t.Fatal(lerr)
+func TestSendContextCancel(t *testing.T) {
+	// Zabbix server that accepts the connection but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
 	}
 	defer listener.Close()
 
-	errs := make(chan error, 1)
-
-	go func(chan error) {
-		for i := 0; i < 2; i++ {
+	go func() {
+		for {
 			conn, err := listener.Accept()
 			if err != nil {
-				errs <- err
+				return
 			}
-
-			// Obtain request from the mock zabbix server
-			// Read protocol header and version
-			header := make([]byte, 5)
-			_, err = conn.Read(header)
-			if err != nil {
-				errs <- err
-			}
-
-			// Read data length
-			dataLengthRaw := make([]byte, 8)
-			_, err = conn.Read(dataLengthRaw)
-			if err != nil {
-				errs <- err
-			}
-
-			dataLength := binary.LittleEndian.Uint64(dataLengthRaw)
-
-			// Read data content
-			content := make([]byte, dataLength)
-			_, err = conn.Read(content)
-			if err != nil {
-				errs <- err
-			}
-
-			// Strip zabbix header and get JSON request
-			var request ZabbixRequest
-			err = json.Unmarshal(content, &request)
-			if err != nil {
-				errs <- err
-			}
-
-			resp := []byte("")
-
-			if request.Request == "sender data" {
-				resp = []byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"info\":\"processed: 1; failed: 0; total: 1; seconds spent: 0.000030\"}")
-			} else if request.Request == "agent data" {
-				resp = []byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"info\":\"processed: 1; failed: 0; total: 1; seconds spent: 0.111111\"}")
-			}
-
-			// The zabbix output checks that there are not errors
-			_, err = conn.Write(resp)
-			if err != nil {
-				errs <- err
-			}
-
-			// Close connection after reading the client data
-			conn.Close()
+			defer conn.Close()
 		}
+	}()
 
-		// End zabbix fake backend
-		errs <- nil
-	}(errs)
-
-	m := NewMetric("zabbixAgent1", "ping", "13", true)
-	m2 := NewMetric("zabbixTrapper1", "pong", "13", false)
+	s := NewSender(listener.Addr().String())
+	packet := NewPacket([]*Metric{NewMetric("zabbixTrapper1", "ping", "13", false)}, false)
 
-	s := NewSender(zabbixHost)
-	resActive, errActive, resTrapper, errTrapper := s.SendMetrics([]*Metric{m, m2})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
 
-	if errActive != nil {
-		t.Fatalf("error sending active metric: %v", errActive)
+	start := time.Now()
+	_, err = s.SendContext(ctx, packet)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
 	}
-	if errTrapper != nil {
-		t.Fatalf("error sending trapper metric: %v", errTrapper)
+	if elapsed := time.Since(start); elapsed > s.ReadTimeout/2 {
+		t.Errorf("context deadline not respected, send took %v", elapsed)
 	}
 
-	raInfo, err := resActive.GetInfo()
-	if err != nil {
-		t.Fatalf("error in response Trapper: %v", err)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = s.RegisterHostContext(ctx, "prueba", "prueba"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
 	}
+}
 
-	if raInfo.Failed != 0 {
-		t.Errorf("Failed error expected 0 got %d", raInfo.Failed)
+func TestSendStrict(t *testing.T) {
+	reply := func(header, data []byte) []byte {
+		return zabbixResponse(`{"response":"success","info":"processed: 1; failed: 2; total: 3; seconds spent: 0.000030"}`)
 	}
-	if raInfo.Processed != 1 {
-		t.Errorf("Processed error expected 1 got %d", raInfo.Processed)
-	}
-	if raInfo.Total != 1 {
-		t.Errorf("Total error expected 1 got %d", raInfo.Total)
+	metrics := []*Metric{
+		NewMetric("zabbixTrapper1", "ping", "13", false),
+		NewMetric("zabbixTrapper1", "pong", "13", false),
+		NewMetric("zabbixTrapper1", "pang", "13", false),
 	}
 
-	rtInfo, err := resTrapper.GetInfo()
-	if err != nil {
-		t.Fatalf("error in response Trapper: %v", err)
+	addr, errs := fakeZabbix(t, 2, reply)
+	s := NewSender(addr)
+
+	if _, err := s.Send(NewPacket(metrics, false)); err != nil {
+		t.Fatalf("partial failures should be ignored without strict mode: %v", err)
 	}
 
-	if rtInfo.Failed != 0 {
-		t.Errorf("Failed error expected 0 got %d", rtInfo.Failed)
+	s.Strict = true
+	_, err := s.Send(NewPacket(metrics, false))
+
+	var partial *PartialFailureError
+	if !errors.Is(err, ErrPartialFailure) || !errors.As(err, &partial) {
+		t.Fatalf("expected partial failure error, got %v", err)
 	}
-	if rtInfo.Processed != 1 {
-		t.Errorf("Processed error expected 1 got %d", rtInfo.Processed)
+	if partial.Info.Failed != 2 || partial.Info.Total != 3 {
+		t.Errorf("unexpected info %+v", partial.Info)
 	}
-	if rtInfo.Total != 1 {
-		t.Errorf("Total error expected 1 got %d", rtInfo.Total)
+	if len(partial.Metrics) != 3 || partial.Metrics[0] != metrics[0] {
+		t.Errorf("expected the sent metrics in the error, got %v", partial.Metrics)
 	}
 
-	// Wait for zabbix server emulator to finish
-	err = <-errs
-	if err != nil {
+	if err = <-errs; err != nil {
 		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
 	}
-}
-
-func TestRegisterHostOK(t *testing.T) {
-	zabbixHost := "127.0.0.1:10051"
 
-	// Simulate a Zabbix server to get the data sent
-	listener, lerr := net.Listen("tcp", zabbixHost)
-	if lerr != nil {
-		t.Fatal(lerr)
+	// Batch results keep the counters of partially failed packets
+	addr, errs = fakeZabbix(t, 1, reply)
+	s.Host = addr
+	res := s.SendBatch(metrics)
+	if !errors.Is(res.Err, ErrPartialFailure) {
+		t.Errorf("expected partial failure error, got %v", res.Err)
 	}
-	defer listener.Close()
-
-	errs := make(chan error, 1)
-
-	go func(chan error) {
-		for i := 0; i < 2; i++ {
-			conn, err := listener.Accept()
-			if err != nil {
-				errs <- err
-			}
-
-			// Obtain request from the mock zabbix server
-			// Read protocol header and version
-			header := make([]byte, 5)
-			_, err = conn.Read(header)
-			if err != nil {
-				errs <- err
-			}
-
-			// Read data length
-			dataLengthRaw := make([]byte, 8)
-			_, err = conn.Read(dataLengthRaw)
-			if err != nil {
-				errs <- err
-			}
-
-			dataLength := binary.LittleEndian.Uint64(dataLengthRaw)
-
-			// Read data content
-			content := make([]byte, dataLength)
-			_, err = conn.Read(content)
-			if err != nil {
-				errs <- err
-			}
-
-			// Strip zabbix header and get JSON request
-			var request ZabbixRequest
-			err = json.Unmarshal(content, &request)
-			if err != nil {
-				errs <- err
-			}
-
-			expectedRequest := "active checks"
-			if expectedRequest != request.Request {
-				errs <- fmt.Errorf("Incorrect request field received, expected '%s'", expectedRequest)
-			}
-
-			// If the host does not exist, the first response will be an error
-			resp := []byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"failed\",\"info\": \"host [prueba] not found\"}")
-
-			// Next response is the valid one
-			if i == 1 {
-				resp = []byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"data\": [{\"key\":\"net.if.in[eth0]\",\"delay\":60,\"lastlogsize\":0,\"mtime\":0}]}")
-			}
-
-			_, err = conn.Write(resp)
-			if err != nil {
-				errs <- err
-			}
-
-			// Close connection after reading the client data
-			conn.Close()
-		}
-
-		// End zabbix fake backend
-		errs <- nil
-	}(errs)
-
-	s := NewSender(zabbixHost)
-	err := s.RegisterHost("prueba", "prueba")
-	if err != nil {
-		t.Fatalf("register host error: %v", err)
+	if res.Processed() != 1 || res.Failed() != 2 {
+		t.Errorf("unexpected counters processed=%d failed=%d", res.Processed(), res.Failed())
 	}
 
-	// Wait for zabbix server emulator to finish
-	err = <-errs
-	if err != nil {
+	if err = <-errs; err != nil {
 		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
 	}
 }
 
-func TestRegisterHostError(t *testing.T) {
-	zabbixHost := "127.0.0.1:10051"
-
-	// Simulate a Zabbix server to get the data sent
-	listener, lerr := net.Listen("tcp", zabbixHost)
-	if lerr != nil {
-		t.Fatal(lerr)
+func TestSendBisect(t *testing.T) {
+	var metrics []*Metric
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("ok%d", i)
+		if i == 2 || i == 6 {
+			key = fmt.Sprintf("bad%d", i)
+		}
+		metrics = append(metrics, NewMetric("zabbixTrapper1", key, "13", false, 1600000000))
 	}
-	defer listener.Close()
-
-	errs := make(chan error, 1)
 
-	go func(chan error) {
-		for i := 0; i < 2; i++ {
-			conn, err := listener.Accept()
-			if err != nil {
-				errs <- err
-			}
-
-			// Obtain request from the mock zabbix server
-			// Read protocol header and version
-			header := make([]byte, 5)
-			_, err = conn.Read(header)
-			if err != nil {
-				errs <- err
-			}
-
-			// Read data length
-			dataLengthRaw := make([]byte, 8)
-			_, err = conn.Read(dataLengthRaw)
-			if err != nil {
-				errs <- err
-			}
-
-			dataLength := binary.LittleEndian.Uint64(dataLengthRaw)
-
-			// Read data content
-			content := make([]byte, dataLength)
-			_, err = conn.Read(content)
-			if err != nil {
-				errs <- err
-			}
-
-			// Strip zabbix header and get JSON request
-			var request ZabbixRequest
-			err = json.Unmarshal(content, &request)
-			if err != nil {
-				errs <- err
-			}
+	// One request for the whole packet, then the left halves of the
+	// bisection: [0:4] [0:2] [2:3] [4:6] [6:7]
+	addr, errs := fakeZabbix(t, 6, func(header, data []byte) []byte {
+		var request ZabbixRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
+		}
 
-			expectedRequest := "active checks"
-			if expectedRequest != request.Request {
-				errs <- fmt.Errorf("Incorrect request field received, expected '%s'", expectedRequest)
+		var failed int
+		for _, m := range request.Data {
+			if strings.HasPrefix(m.Key, "bad") {
+				failed++
 			}
-
-			// Simulate error always
-			resp := []byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"failed\",\"info\": \"host [prueba] not found\"}")
-			_, err = conn.Write(resp)
-			if err != nil {
-				errs <- err
+			if m.Clock != 1600000000 {
+				t.Errorf("metric %s resent with clock %d", m.Key, m.Clock)
 			}
-
-			// Close connection after reading the client data
-			conn.Close()
 		}
+		return zabbixResponse(fmt.Sprintf(`{"response":"success","info":"processed: %d; failed: %d; total: %d; seconds spent: 0.000030"}`,
+			len(request.Data)-failed, failed, len(request.Data)))
+	})
 
-		// End zabbix fake backend
-		errs <- nil
-	}(errs)
+	s := NewSender(addr)
+	s.Bisect = true
+	_, err := s.Send(NewPacket(metrics, false))
 
-	s := NewSender(zabbixHost)
-	err := s.RegisterHost("prueba", "prueba")
-	if err == nil {
-		t.Fatalf("should return an error: %v", err)
+	var partial *PartialFailureError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected partial failure error, got %v", err)
+	}
+	if len(partial.Rejected) != 2 || partial.Rejected[0] != metrics[2] || partial.Rejected[1] != metrics[6] {
+		t.Errorf("expected metrics bad2 and bad6 to be rejected, got %v", partial.Rejected)
 	}
 
-	// Wait for zabbix server emulator to finish
-	err = <-errs
-	if err != nil {
+	if err = <-errs; err != nil {
 		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
 	}
 }
 
-func TestInvalidResponseHeader(t *testing.T) {
-	zabbixHost := "127.0.0.1:10051"
-
-	// Simulate a Zabbix server to get the data sent
-	listener, lerr := net.Listen("tcp", zabbixHost)
-	if lerr != nil {
-		t.Fatal(lerr)
+func TestMetricTimeJSON(t *testing.T) {
+	valueTime := time.Unix(1700000000, 123456789)
+	packetTime := time.Unix(1700000001, 5)
+
+	// As sent by zabbix_sender with timestamps and nanoseconds
+	packet := NewPacketTime([]*Metric{
+		NewMetricTime("host", "key", "1", false, valueTime),
+		NewMetricTime("host", "key", "2", false, time.Unix(1700000002, 0)),
+	}, false, packetTime)
+	expected := `{"request":"sender data","data":[` +
+		`{"host":"host","key":"key","value":"1","clock":1700000000,"ns":123456789},` +
+		`{"host":"host","key":"key","value":"2","clock":1700000002,"ns":0}],` +
+		`"clock":1700000001,"ns":5}`
+
+	data, err := json.Marshal(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("unexpected JSON\n got: %s\nwant: %s", data, expected)
 	}
-	defer listener.Close()
 
-	errs := make(chan error, 1)
+	var decoded Packet
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Ns != 5 || decoded.Data[0].Clock != valueTime.Unix() || decoded.Data[0].Ns != valueTime.Nanosecond() {
+		t.Errorf("unexpected decoded packet %+v, metric %+v", decoded, decoded.Data[0])
+	}
 
-	go func(chan error) {
-		conn, err := listener.Accept()
-		if err != nil {
-			errs <- err
-		}
+	// Decoded metrics are encoded again as received, a clock alone is sent
+	// without ns
+	packet = NewPacket([]*Metric{decoded.Data[1], NewMetric("host", "key", "3", false, 1700000003)}, false)
+	expected = `{"request":"sender data","data":[` +
+		`{"host":"host","key":"key","value":"2","clock":1700000002,"ns":0},` +
+		`{"host":"host","key":"key","value":"3","clock":1700000003}]}`
+	if data, _ = json.Marshal(packet); string(data) != expected {
+		t.Errorf("unexpected JSON\n got: %s\nwant: %s", data, expected)
+	}
+}
 
-		// Obtain request from the mock zabbix server
-		// Read protocol header and version
-		header := make([]byte, 5)
-		_, err = conn.Read(header)
-		if err != nil {
-			errs <- err
+func TestSendStamp(t *testing.T) {
+	// The first connection is closed without a response, so the packet is
+	// sent twice
+	var packets []Packet
+	addr, errs := fakeZabbix(t, 2, func(header, data []byte) []byte {
+		var packet Packet
+		if err := json.Unmarshal(data, &packet); err != nil {
+			t.Errorf("request is not valid JSON: %v", err)
 		}
-
-		// Read data length
-		dataLengthRaw := make([]byte, 8)
-		_, err = conn.Read(dataLengthRaw)
-		if err != nil {
-			errs <- err
+		packets = append(packets, packet)
+		if len(packets) == 1 {
+			return nil
 		}
+		return zabbixResponse(`{"response":"success","info":"processed: 2; failed: 0; total: 2; seconds spent: 0.000030"}`)
+	})
 
-		dataLength := binary.LittleEndian.Uint64(dataLengthRaw)
+	s := NewSender(addr)
+	s.StampPackets = true
+	s.StampMetrics = true
+	s.Retry = &RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond}
 
-		// Read data content
-		content := make([]byte, dataLength)
-		_, err = conn.Read(content)
-		if err != nil {
-			errs <- err
-		}
-
-		// The zabbix output checks that there are not errors
-		// Zabbix header length not used, set to 1
-		resp := []byte("BXD\x01\x00\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"info\":\"processed: 1; failed: 0; total: 1; seconds spent: 0.000030\"}")
-		_, err = conn.Write(resp)
-		if err != nil {
-			errs <- err
-		}
+	before := time.Now()
+	metrics := []*Metric{
+		NewMetric("host", "key", "1", false),
+		NewMetric("host", "key", "2", false, 1700000000),
+	}
+	if _, err := s.Send(NewPacket(metrics, false)); err != nil {
+		t.Fatalf("error sending packet: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	}
 
-		// Close connection after reading the client data
-		conn.Close()
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	first, second := packets[0], packets[1]
+	if first.Clock < before.Unix() || time.Unix(second.Clock, int64(second.Ns)).Before(time.Unix(first.Clock, int64(first.Ns)).Add(time.Millisecond)) {
+		t.Errorf("expected each attempt to be stamped, got %d.%09d and %d.%09d", first.Clock, first.Ns, second.Clock, second.Ns)
+	}
 
-		// Strip zabbix header and get JSON request
-		var request ZabbixRequest
-		err = json.Unmarshal(content, &request)
-		if err != nil {
-			errs <- err
+	// Metrics are stamped once, when first sent
+	for _, p := range packets {
+		if p.Data[0].Clock != first.Data[0].Clock || p.Data[0].Ns != first.Data[0].Ns || p.Data[0].Clock < before.Unix() {
+			t.Errorf("unexpected metric time %d.%09d", p.Data[0].Clock, p.Data[0].Ns)
 		}
-
-		expectedRequest := "agent data"
-		if expectedRequest != request.Request {
-			errs <- fmt.Errorf("Incorrect request field received, expected '%s'", expectedRequest)
+		if p.Data[1].Clock != 1700000000 || p.Data[1].Ns != 0 {
+			t.Errorf("expected the metric clock to be kept, got %d.%09d", p.Data[1].Clock, p.Data[1].Ns)
 		}
-
-		// End zabbix fake backend
-		errs <- nil
-	}(errs)
-
-	m := NewMetric("zabbixAgent1", "ping", "13", true)
-
-	s := NewSender(zabbixHost)
-	_, errActive, _, _ := s.SendMetrics([]*Metric{m})
-	if errActive == nil {
-		t.Fatal("Expected an error because an incorrect Zabbix protocol header")
 	}
-
-	// Wait for zabbix server emulator to finish
-	err := <-errs
-	if err != nil {
-		t.Fatalf("Fake zabbix backend should not produce any errors: %v", err)
+	if metrics[0].Clock != 0 {
+		t.Errorf("expected the given metric not to be modified, got clock %d", metrics[0].Clock)
 	}
 }
@@ -0,0 +1,298 @@
+// Package zabbixtest provides a fake Zabbix server to test the code sending
+// data with the zabbix package.
+package zabbixtest
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	zabbix "github.com/spetr/go-zabbix-sender"
+)
+
+// Reply is a scripted reply of the Server.
+type Reply struct {
+	// Response is "success" when empty.
+	Response string
+
+	// Info of the response. For "sender data" and "agent data" requests it
+	// defaults to all the values processed.
+	Info string
+
+	// Data and Regexp are the active checks returned to "active checks"
+	// requests.
+	Data   []zabbix.ActiveCheck
+	Regexp []zabbix.Regexp
+
+	// Raw, if set, is written as is instead of the response, for example
+	// an invalid frame.
+	Raw []byte
+
+	// Drop closes the connection without response.
+	Drop bool
+}
+
+// Server is a fake Zabbix server listening on an ephemeral port of the
+// loopback interface. It records the packets it receives and replies with
+// the Reply scripted for their request, or a successful response.
+type Server struct {
+	// Addr is the address of the server, "127.0.0.1:port".
+	Addr string
+
+	t        testing.TB
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	packets  []*zabbix.Packet
+	replies  map[string][]Reply
+	received *sync.Cond
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewServer start a Server, closed when the test ends. Invalid requests
+// fail t.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("zabbixtest: listening: %v", err)
+	}
+
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		t:        t,
+		listener: listener,
+		replies:  make(map[string][]Reply),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.received = sync.NewCond(&s.mu)
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stop the server and close the connections in progress.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Respond queue replies to the next requests of type request, such as
+// "sender data", "agent data" or "active checks". Once they are used the
+// server replies successfully again.
+func (s *Server) Respond(request string, replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[request] = append(s.replies[request], replies...)
+}
+
+// Packets return the packets received so far.
+func (s *Server) Packets() []*zabbix.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*zabbix.Packet(nil), s.packets...)
+}
+
+// Metrics return the metrics of the packets received so far. The metrics of
+// "agent data" packets are Active.
+func (s *Server) Metrics() []*zabbix.Metric {
+	var metrics []*zabbix.Metric
+	for _, p := range s.Packets() {
+		metrics = append(metrics, p.Data...)
+	}
+	return metrics
+}
+
+// WaitPackets wait until the server received n packets and return them. It
+// fails t if they are not received within timeout.
+func (s *Server) WaitPackets(t testing.TB, n int, timeout time.Duration) []*zabbix.Packet {
+	t.Helper()
+
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.received.Broadcast()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	s.mu.Lock()
+	for len(s.packets) < n && time.Now().Before(deadline) {
+		s.received.Wait()
+	}
+	packets := append([]*zabbix.Packet(nil), s.packets...)
+	s.mu.Unlock()
+
+	if len(packets) < n {
+		t.Fatalf("zabbixtest: received %d packets after %v, expected %d", len(packets), timeout, n)
+	}
+	return packets
+}
+
+// AssertRequests check the requests of the packets received so far.
+func (s *Server) AssertRequests(t testing.TB, requests ...string) {
+	t.Helper()
+
+	packets := s.Packets()
+	got := make([]string, len(packets))
+	for i, p := range packets {
+		got[i] = p.Request
+	}
+
+	if len(got) != len(requests) {
+		t.Errorf("zabbixtest: received requests %q, expected %q", got, requests)
+		return
+	}
+	for i := range got {
+		if got[i] != requests[i] {
+			t.Errorf("zabbixtest: received requests %q, expected %q", got, requests)
+			return
+		}
+	}
+}
+
+// AssertMetric check that a metric of host and key was received with value.
+func (s *Server) AssertMetric(t testing.TB, host, key, value string) {
+	t.Helper()
+
+	var values []string
+	for _, m := range s.Metrics() {
+		if m.Host == host && m.Key == key {
+			if m.Value == value {
+				return
+			}
+			values = append(values, m.Value)
+		}
+	}
+
+	if len(values) == 0 {
+		t.Errorf("zabbixtest: no metric received for host %q and key %q", host, key)
+	} else {
+		t.Errorf("zabbixtest: metric %s:%s received with values %q, expected %q", host, key, values, value)
+	}
+}
+
+// AssertMetricCount check the number of metrics received so far.
+func (s *Server) AssertMetricCount(t testing.TB, n int) {
+	t.Helper()
+
+	if got := len(s.Metrics()); got != n {
+		t.Errorf("zabbixtest: received %d metrics, expected %d", got, n)
+	}
+}
+
+// serve the connections until the listener is closed.
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			conn.Close()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// response is the JSON encoding of a Reply.
+type response struct {
+	Response string               `json:"response"`
+	Info     string               `json:"info,omitempty"`
+	Data     []zabbix.ActiveCheck `json:"data,omitempty"`
+	Regexp   []zabbix.Regexp      `json:"regexp,omitempty"`
+}
+
+// handle a request, one per connection.
+func (s *Server) handle(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	h, data, err := zabbix.NewDecoder(conn).Decode()
+	if err != nil {
+		s.error("zabbixtest: reading request: %v", err)
+		return
+	}
+
+	packet := new(zabbix.Packet)
+	if err := json.Unmarshal(data, packet); err != nil {
+		s.error("zabbixtest: request is not valid JSON: %v", err)
+		return
+	}
+	for _, m := range packet.Data {
+		m.Active = packet.Request == "agent data"
+	}
+
+	s.mu.Lock()
+	s.packets = append(s.packets, packet)
+	s.received.Broadcast()
+	var reply Reply
+	if replies := s.replies[packet.Request]; len(replies) > 0 {
+		reply = replies[0]
+		s.replies[packet.Request] = replies[1:]
+	}
+	s.mu.Unlock()
+
+	switch {
+	case reply.Drop:
+		return
+	case reply.Raw != nil:
+		conn.Write(reply.Raw)
+		return
+	}
+
+	res := response{Response: reply.Response, Info: reply.Info, Data: reply.Data, Regexp: reply.Regexp}
+	if res.Response == "" {
+		res.Response = "success"
+	}
+	if res.Info == "" && (packet.Request == "sender data" || packet.Request == "agent data") {
+		n := len(packet.Data)
+		res.Info = (&zabbix.ResponseInfo{Processed: n, Total: n, Spent: 10 * time.Microsecond}).String()
+	}
+
+	enc := zabbix.NewEncoder(conn)
+	enc.Compress = h.Flags&zabbix.FlagCompressed != 0
+	if err := enc.EncodeJSON(&res); err != nil {
+		s.error("zabbixtest: writing response: %v", err)
+	}
+}
+
+// error fail the test, unless the server is closed.
+func (s *Server) error(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.t.Errorf(format, args...)
+	}
+}
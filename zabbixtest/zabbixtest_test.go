@@ -0,0 +1,90 @@
+package zabbixtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	zabbix "github.com/spetr/go-zabbix-sender"
+	"github.com/spetr/go-zabbix-sender/zabbixtest"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	s := zabbix.NewSender(srv.Addr)
+	s.Compress = true
+	res := s.SendBatch([]*zabbix.Metric{
+		zabbix.NewMetric("host", "trapper", "1", false),
+		zabbix.NewMetric("host", "active", "2", true),
+	})
+	if res.Err != nil {
+		t.Fatalf("error sending metrics: %v", res.Err)
+	}
+	if res.Processed() != 2 || res.Failed() != 0 {
+		t.Errorf("unexpected result processed=%d failed=%d", res.Processed(), res.Failed())
+	}
+
+	srv.AssertRequests(t, "sender data", "agent data")
+	srv.AssertMetricCount(t, 2)
+	srv.AssertMetric(t, "host", "trapper", "1")
+	srv.AssertMetric(t, "host", "active", "2")
+	if m := srv.Metrics(); m[0].Active || !m[1].Active {
+		t.Errorf("expected only the agent data metric to be active")
+	}
+}
+
+func TestServerReplies(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+	srv.Respond("active checks",
+		zabbixtest.Reply{Response: "failed", Info: "host [host] not found"},
+		zabbixtest.Reply{Data: []zabbix.ActiveCheck{{Key: "agent.ping", Delay: "60"}}},
+	)
+	srv.Respond("sender data",
+		zabbixtest.Reply{Info: "processed: 0; failed: 1; total: 1; seconds spent: 0.000010"},
+		zabbixtest.Reply{Drop: true},
+		zabbixtest.Reply{Raw: []byte("invalid")},
+	)
+
+	s := zabbix.NewSender(srv.Addr)
+	if _, _, err := s.GetActiveChecks("host", ""); !errors.Is(err, zabbix.ErrServerRejected) {
+		t.Errorf("expected rejected error, got %v", err)
+	}
+	checks, _, err := s.GetActiveChecks("host", "")
+	if err != nil || len(checks) != 1 || checks[0].Key != "agent.ping" || checks[0].Delay != "60" {
+		t.Errorf("unexpected active checks %+v, error %v", checks, err)
+	}
+
+	s.Strict = true
+	packet := zabbix.NewPacket([]*zabbix.Metric{zabbix.NewMetric("host", "key", "1", false)}, false)
+	if _, err := s.Send(packet); !errors.Is(err, zabbix.ErrPartialFailure) {
+		t.Errorf("expected partial failure error, got %v", err)
+	}
+	if _, err := s.Send(packet); !errors.Is(err, zabbix.ErrTruncatedFrame) {
+		t.Errorf("expected truncated frame error, got %v", err)
+	}
+	if _, err := s.Send(packet); !errors.Is(err, zabbix.ErrBadMagic) {
+		t.Errorf("expected bad magic error, got %v", err)
+	}
+	// The scripted replies are used, the default one follows
+	if _, err := s.Send(packet); err != nil {
+		t.Errorf("error sending packet: %v", err)
+	}
+}
+
+func TestServerWaitPackets(t *testing.T) {
+	t.Parallel()
+	srv := zabbixtest.NewServer(t)
+
+	b := zabbix.NewBufferedSender(zabbix.NewSender(srv.Addr), zabbix.BufferedConfig{FlushInterval: 10 * time.Millisecond})
+	defer b.Close(context.Background())
+	b.Add(zabbix.NewMetric("host", "key", "1", false))
+
+	packets := srv.WaitPackets(t, 1, 5*time.Second)
+	if len(packets[0].Data) != 1 || packets[0].Data[0].Value != "1" {
+		t.Errorf("unexpected packet %+v", packets[0])
+	}
+}